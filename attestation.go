@@ -0,0 +1,65 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+)
+
+// AttestationVerifier is a pluggable hook invoked during the TLS handshake,
+// in addition to normal certificate validation, to check confidential
+// computing attestation evidence (e.g. an SEV-SNP or TDX quote) carried by
+// the peer, composing aTLS-style attestation with ordinary (m)TLS the same
+// way aTLS+mTLS deployments do.
+type AttestationVerifier interface {
+	// VerifyAttestation inspects the peer's verified certificate chain and
+	// returns an error to fail the handshake if the attestation evidence
+	// does not check out.
+	VerifyAttestation(chain []*x509.Certificate) error
+}
+
+// attestationVerifiers are the built-in AttestationVerifier implementations
+// selectable via -attestation-report. It is empty out of the box: wiring up
+// an actual SEV-SNP/TDX quote verifier requires platform-specific attestation
+// libraries this tool does not vendor, so integrators register their own
+// verifier here (or fork lookupAttestationVerifier) rather than the probe
+// shipping one.
+var attestationVerifiers = map[string]AttestationVerifier{}
+
+func lookupAttestationVerifier(name string) (AttestationVerifier, error) {
+	v, ok := attestationVerifiers[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown -attestation-report verifier %q (none registered)", name)
+	}
+	return v, nil
+}
+
+// applyAttestation appends verifier's check to cfg.VerifyPeerCertificate,
+// running it after whatever chain validation cfg already performs.
+func applyAttestation(cfg *tls.Config, verifier AttestationVerifier) {
+	prev := cfg.VerifyPeerCertificate
+	cfg.VerifyPeerCertificate = func(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error {
+		if prev != nil {
+			if err := prev(rawCerts, verifiedChains); err != nil {
+				return err
+			}
+		}
+		if len(verifiedChains) == 0 {
+			return fmt.Errorf("no verified certificate chain to attest")
+		}
+		return verifier.VerifyAttestation(verifiedChains[0])
+	}
+}
@@ -0,0 +1,159 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Command grpc-health-probe-batch probes many (addr, service) combinations
+// concurrently and reports a single aggregate result, so that smoke tests and
+// CI pipelines don't need to fork one grpc-health-probe process per target in
+// a shell loop.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	grpc_health_probe "github.com/grpc-ecosystem/grpc-health-probe/pkg"
+)
+
+var (
+	flAddrs          stringSliceFlag
+	flServices       stringSliceFlag
+	flMaxConcurrency int
+	flOutput         string
+	flConnTimeout    time.Duration
+	flRPCTimeout     time.Duration
+)
+
+type stringSliceFlag []string
+
+func (s *stringSliceFlag) String() string { return fmt.Sprintf("%v", []string(*s)) }
+func (s *stringSliceFlag) Set(v string) error {
+	*s = append(*s, v)
+	return nil
+}
+
+// Result is one (addr, service) probe outcome, suitable for JSON output.
+type Result struct {
+	Addr      string `json:"addr"`
+	Service   string `json:"service"`
+	Status    string `json:"status"`
+	ConnectMS int64  `json:"connect_ms"`
+	RPCMS     int64  `json:"rpc_ms"`
+	Error     string `json:"error,omitempty"`
+	ExitCode  int    `json:"exit_code"`
+}
+
+func probeOne(ctx context.Context, addr, service string) Result {
+	cfg := &grpc_health_probe.Config{
+		Addr:        addr,
+		Service:     service,
+		UserAgent:   "grpc_health_probe_batch",
+		ConnTimeout: flConnTimeout,
+		RPCTimeout:  flRPCTimeout,
+	}
+
+	result, err := grpc_health_probe.Check(ctx, cfg)
+
+	var connectMS, rpcMS int64
+	if result != nil {
+		connectMS = result.ConnectDuration.Milliseconds()
+		rpcMS = result.RPCDuration.Milliseconds()
+	}
+
+	if err != nil {
+		status := "UNKNOWN"
+		if result != nil && result.Response != nil {
+			status = result.Response.GetStatus().String()
+		}
+		return Result{Addr: addr, Service: service, Status: status, ConnectMS: connectMS, RPCMS: rpcMS, Error: err.Error(), ExitCode: err.ExitCode}
+	}
+	return Result{Addr: addr, Service: service, Status: result.Response.GetStatus().String(), ConnectMS: connectMS, RPCMS: rpcMS, ExitCode: 0}
+}
+
+func parseFlags() {
+	flag.Var(&flAddrs, "addr", "tcp host:port to connect (may be repeated for multiple targets)")
+	flag.Var(&flServices, "service", "service name to check (may be repeated; default: probe all -addr with the empty service name)")
+	flag.IntVar(&flMaxConcurrency, "max-concurrency", 10, "maximum number of probes to run concurrently")
+	flag.StringVar(&flOutput, "output", "text", "output format: text or json")
+	flag.DurationVar(&flConnTimeout, "connect-timeout", time.Second, "timeout for establishing each connection")
+	flag.DurationVar(&flRPCTimeout, "rpc-timeout", time.Second, "timeout for each health check rpc")
+	flag.Parse()
+}
+
+func main() {
+	log.SetFlags(0)
+	parseFlags()
+
+	if len(flAddrs) == 0 {
+		log.Fatal("error: at least one -addr must be specified")
+	}
+	if flMaxConcurrency <= 0 {
+		log.Fatal("error: -max-concurrency must be greater than zero")
+	}
+	services := flServices
+	if len(services) == 0 {
+		services = stringSliceFlag{""}
+	}
+
+	type job struct{ addr, service string }
+	var jobs []job
+	for _, addr := range flAddrs {
+		for _, service := range services {
+			jobs = append(jobs, job{addr, service})
+		}
+	}
+
+	results := make([]Result, len(jobs))
+	sem := make(chan struct{}, flMaxConcurrency)
+	var wg sync.WaitGroup
+	for i, j := range jobs {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, j job) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			results[i] = probeOne(context.Background(), j.addr, j.service)
+		}(i, j)
+	}
+	wg.Wait()
+
+	exitCode := 0
+	for _, r := range results {
+		if r.ExitCode > exitCode {
+			exitCode = r.ExitCode
+		}
+	}
+
+	if flOutput == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		for _, r := range results {
+			enc.Encode(r)
+		}
+	} else {
+		for _, r := range results {
+			if r.Error != "" {
+				fmt.Printf("%s service=%q status=%s error=%q\n", r.Addr, r.Service, r.Status, r.Error)
+			} else {
+				fmt.Printf("%s service=%q status=%s\n", r.Addr, r.Service, r.Status)
+			}
+		}
+	}
+
+	os.Exit(exitCode)
+}
@@ -0,0 +1,153 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Command grpc-health-probe-exporter runs grpc-health-probe as a long-lived
+// HTTP endpoint modeled on blackbox_exporter's /probe handler: each request
+// performs a one-shot gRPC health check against the requested target and
+// renders the result as Prometheus text-format metrics, instead of a single
+// exec'd process per Kubernetes probe.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	grpc_health_probe "github.com/grpc-ecosystem/grpc-health-probe/pkg"
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	flListenAddr string
+	flConfigFile string
+)
+
+func init() {
+	flag.StringVar(&flListenAddr, "exporter-listen", ":9115", "address to serve the /probe endpoint on")
+	flag.StringVar(&flConfigFile, "config", "", "path to a YAML file defining probe modules (optional)")
+}
+
+// Module describes the default probe settings used when a /probe request
+// does not override them via query parameters.
+type Module struct {
+	Service     string        `yaml:"service"`
+	TLS         bool          `yaml:"tls"`
+	TLSCACert   string        `yaml:"tls_ca_cert"`
+	ConnTimeout time.Duration `yaml:"connect_timeout"`
+	RPCTimeout  time.Duration `yaml:"rpc_timeout"`
+}
+
+// Config is the top-level YAML document accepted via -config.
+type Config struct {
+	Modules map[string]Module `yaml:"modules"`
+}
+
+func loadConfig(path string) (*Config, error) {
+	cfg := &Config{Modules: map[string]Module{}}
+	if path == "" {
+		return cfg, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file %q: %v", path, err)
+	}
+	if err := yaml.Unmarshal(data, cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse config file %q: %v", path, err)
+	}
+	return cfg, nil
+}
+
+func main() {
+	flag.Parse()
+	log.SetFlags(0)
+
+	cfg, err := loadConfig(flConfigFile)
+	if err != nil {
+		log.Fatalf("error: %v", err)
+	}
+
+	http.HandleFunc("/probe", probeHandler(cfg))
+	log.Printf("listening on %s", flListenAddr)
+	log.Fatal(http.ListenAndServe(flListenAddr, nil))
+}
+
+func probeHandler(cfg *Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		target := r.URL.Query().Get("target")
+		if target == "" {
+			http.Error(w, "target parameter is required", http.StatusBadRequest)
+			return
+		}
+
+		mod := cfg.Modules[r.URL.Query().Get("module")]
+		if s := r.URL.Query().Get("service"); s != "" {
+			mod.Service = s
+		}
+		connTimeout, rpcTimeout := mod.ConnTimeout, mod.RPCTimeout
+		if connTimeout <= 0 {
+			connTimeout = time.Second
+		}
+		if rpcTimeout <= 0 {
+			rpcTimeout = time.Second
+		}
+
+		probeConfig := &grpc_health_probe.Config{
+			Addr:        target,
+			Service:     mod.Service,
+			UserAgent:   "grpc_health_probe_exporter",
+			ConnTimeout: connTimeout,
+			RPCTimeout:  rpcTimeout,
+			TLS:         mod.TLS,
+			TLSCACert:   mod.TLSCACert,
+		}
+
+		result, probeErr := grpc_health_probe.Check(r.Context(), probeConfig)
+
+		success := 0
+		if probeErr == nil {
+			success = 1
+		}
+
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		fmt.Fprintf(w, "# HELP grpc_health_probe_success Whether the gRPC health probe succeeded.\n")
+		fmt.Fprintf(w, "# TYPE grpc_health_probe_success gauge\n")
+		fmt.Fprintf(w, "grpc_health_probe_success %d\n", success)
+
+		fmt.Fprintf(w, "# HELP grpc_health_probe_duration_seconds Duration of the probe phases.\n")
+		fmt.Fprintf(w, "# TYPE grpc_health_probe_duration_seconds gauge\n")
+		if result != nil {
+			fmt.Fprintf(w, "grpc_health_probe_duration_seconds{phase=\"connect\"} %f\n", result.ConnectDuration.Seconds())
+			fmt.Fprintf(w, "grpc_health_probe_duration_seconds{phase=\"rpc\"} %f\n", result.RPCDuration.Seconds())
+		}
+
+		if result != nil && result.Response != nil {
+			fmt.Fprintf(w, "# HELP grpc_health_probe_serving_status The serving status reported by the last Check response (enum grpc.health.v1.HealthCheckResponse.ServingStatus).\n")
+			fmt.Fprintf(w, "# TYPE grpc_health_probe_serving_status gauge\n")
+			fmt.Fprintf(w, "grpc_health_probe_serving_status %d\n", int32(result.Response.GetStatus()))
+		}
+
+		if result != nil && len(result.PeerCertificates) > 0 {
+			cert := result.PeerCertificates[0]
+			fmt.Fprintf(w, "# HELP grpc_health_probe_tls_cert_expiry_seconds Unix timestamp at which the server's leaf TLS certificate expires.\n")
+			fmt.Fprintf(w, "# TYPE grpc_health_probe_tls_cert_expiry_seconds gauge\n")
+			fmt.Fprintf(w, "grpc_health_probe_tls_cert_expiry_seconds %d\n", cert.NotAfter.Unix())
+			fmt.Fprintf(w, "# HELP grpc_health_probe_tls_cert_not_before_seconds Unix timestamp at which the server's leaf TLS certificate became valid.\n")
+			fmt.Fprintf(w, "# TYPE grpc_health_probe_tls_cert_not_before_seconds gauge\n")
+			fmt.Fprintf(w, "grpc_health_probe_tls_cert_not_before_seconds %d\n", cert.NotBefore.Unix())
+		}
+	}
+}
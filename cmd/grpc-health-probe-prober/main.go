@@ -0,0 +1,189 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Command grpc-health-probe-prober exposes pkg/prober's full feature set
+// (streaming Watch, hot-reloadable/SPIFFE-verified TLS, per-RPC bearer and
+// OAuth2 credentials, a Prometheus exporter, and multi-endpoint quorum
+// checking) as a standalone binary, rather than grpc-health-probe's one-shot
+// Check used by the top-level main.go.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/grpc-ecosystem/grpc-health-probe/pkg/prober"
+)
+
+var (
+	flAddr                 string
+	flService              string
+	flUserAgent            string
+	flConnTimeout          time.Duration
+	flRPCTimeout           time.Duration
+	flTLS                  bool
+	flTLSNoVerify          bool
+	flTLSCACert            string
+	flTLSClientCert        string
+	flTLSClientKey         string
+	flTLSServerName        string
+	flTLSSpiffeID          string
+	flTLSSpiffeTrustDomain string
+	flTLSReload            bool
+	flVerbose              bool
+	flWatch                bool
+	flWatchDuration        time.Duration
+	flBearerToken          string
+	flBearerTokenFile      string
+	flOAuth2TokenURL       string
+	flOAuth2ClientID       string
+	flOAuth2ClientSecret   string
+	flOAuth2Scopes         stringListFlag
+	flAllowInsecureCreds   bool
+	flMulti                bool
+	flQuorum               string
+	flExporterListen       string
+	flExporterInterval     time.Duration
+)
+
+// stringListFlag collects a repeatable flag into an ordered list.
+type stringListFlag []string
+
+func (s *stringListFlag) String() string { return fmt.Sprintf("%v", []string(*s)) }
+func (s *stringListFlag) Set(v string) error {
+	*s = append(*s, v)
+	return nil
+}
+
+func parseFlags() {
+	flag.StringVar(&flAddr, "addr", "", "(required) tcp host:port, dns:/// or xds:/// target to connect")
+	flag.StringVar(&flService, "service", "", "service name to check (default: \"\")")
+	flag.StringVar(&flUserAgent, "user-agent", "grpc_health_probe", "user-agent header value of health check requests")
+	flag.DurationVar(&flConnTimeout, "connect-timeout", time.Second, "timeout for establishing connection")
+	flag.DurationVar(&flRPCTimeout, "rpc-timeout", time.Second, "timeout for health check rpc")
+	flag.BoolVar(&flTLS, "tls", false, "use TLS (default: false, INSECURE plaintext transport)")
+	flag.BoolVar(&flTLSNoVerify, "tls-no-verify", false, "(with -tls) don't verify the certificate (INSECURE) presented by the server")
+	flag.StringVar(&flTLSCACert, "tls-ca-cert", "", "(with -tls, optional) file containing trusted certificates for verifying server")
+	flag.StringVar(&flTLSClientCert, "tls-client-cert", "", "(with -tls, optional) client certificate for authenticating to the server (requires -tls-client-key)")
+	flag.StringVar(&flTLSClientKey, "tls-client-key", "", "(with -tls) client private key for authenticating to the server (requires -tls-client-cert)")
+	flag.StringVar(&flTLSServerName, "tls-server-name", "", "(with -tls) override the hostname used to verify the server certificate")
+	flag.StringVar(&flTLSSpiffeID, "tls-spiffe-id", "", "(with -tls) require the server certificate to carry this exact spiffe:// URI SAN")
+	flag.StringVar(&flTLSSpiffeTrustDomain, "tls-spiffe-trust-domain", "", "(with -tls, and -tls-spiffe-id not set) require the server certificate's spiffe:// URI SAN to belong to this trust domain")
+	flag.BoolVar(&flTLSReload, "tls-reload", false, "(with -tls) reload the client cert/key pair and CA bundle from disk whenever their mtime changes")
+	flag.BoolVar(&flVerbose, "v", false, "verbose logs")
+	flag.BoolVar(&flWatch, "watch", false, "use the streaming Health/Watch RPC instead of Check, exiting once -watch-duration elapses while still SERVING")
+	flag.DurationVar(&flWatchDuration, "watch-duration", 30*time.Second, "(with -watch) how long the server must keep reporting SERVING before the watch succeeds")
+	flag.StringVar(&flBearerToken, "bearer-token", "", "static bearer token attached to every RPC as per-RPC credentials")
+	flag.StringVar(&flBearerTokenFile, "bearer-token-file", "", "like -bearer-token, but reads the token from a file")
+	flag.StringVar(&flOAuth2TokenURL, "oauth2-token-url", "", "fetch per-RPC bearer tokens via the OAuth2 client-credentials flow at this token URL")
+	flag.StringVar(&flOAuth2ClientID, "oauth2-client-id", "", "(with -oauth2-token-url) OAuth2 client ID")
+	flag.StringVar(&flOAuth2ClientSecret, "oauth2-client-secret", "", "(with -oauth2-token-url) OAuth2 client secret")
+	flag.Var(&flOAuth2Scopes, "oauth2-scope", "(with -oauth2-token-url) OAuth2 scope to request; may be specified more than once")
+	flag.BoolVar(&flAllowInsecureCreds, "allow-insecure-credentials", false, "permit per-RPC credentials over a non-TLS transport (INSECURE)")
+	flag.BoolVar(&flMulti, "multi", false, "resolve -addr to its individual backends and Check each one instead of letting a single ClientConn pick one")
+	flag.StringVar(&flQuorum, "quorum", "all", "(with -multi) how per-endpoint results combine into the verdict: \"all\", \"any\", or \"majority\"")
+	flag.StringVar(&flExporterListen, "exporter-listen", "", "if set, instead of probing once, serve Prometheus metrics for a periodic background probe on this address")
+	flag.DurationVar(&flExporterInterval, "exporter-interval", 10*time.Second, "(with -exporter-listen) interval between background probes")
+	flag.Parse()
+}
+
+func main() {
+	log.SetFlags(0)
+	parseFlags()
+
+	cfg := &prober.Config{
+		Addr:                     flAddr,
+		Service:                  flService,
+		UserAgent:                flUserAgent,
+		ConnTimeout:              flConnTimeout,
+		RPCTimeout:               flRPCTimeout,
+		TLS:                      flTLS,
+		TLSNoVerify:              flTLSNoVerify,
+		TLSCACert:                flTLSCACert,
+		TLSClientCert:            flTLSClientCert,
+		TLSClientKey:             flTLSClientKey,
+		TLSServerName:            flTLSServerName,
+		TLSSpiffeID:              flTLSSpiffeID,
+		TLSSpiffeTrustDomain:     flTLSSpiffeTrustDomain,
+		TLSReload:                flTLSReload,
+		Verbose:                  flVerbose,
+		Watch:                    flWatch,
+		WatchDuration:            flWatchDuration,
+		BearerToken:              flBearerToken,
+		BearerTokenFile:          flBearerTokenFile,
+		OAuth2TokenURL:           flOAuth2TokenURL,
+		OAuth2ClientID:           flOAuth2ClientID,
+		OAuth2ClientSecret:       flOAuth2ClientSecret,
+		OAuth2Scopes:             flOAuth2Scopes,
+		AllowInsecureCredentials: flAllowInsecureCreds,
+	}
+
+	checker, cerr := prober.NewChecker(cfg, log.Default())
+	if cerr != nil {
+		log.Printf("error: %s", cerr.Error())
+		os.Exit(cerr.ExitCode)
+	}
+
+	if flExporterListen != "" {
+		exporter := prober.NewExporter(checker, flExporterInterval)
+		go exporter.Run(context.Background())
+		http.Handle("/metrics", exporter)
+		log.Printf("serving /metrics on %s", flExporterListen)
+		log.Fatal(http.ListenAndServe(flExporterListen, nil))
+	}
+
+	ctx := context.Background()
+
+	if flMulti {
+		results, cerr := checker.MultiCheck(ctx)
+		if cerr != nil {
+			log.Printf("error: %s", cerr.Error())
+			os.Exit(cerr.ExitCode)
+		}
+		for _, r := range results {
+			if r.Err != nil {
+				log.Printf("endpoint=%s error=%s", r.Addr, r.Err.Error())
+			} else {
+				log.Printf("endpoint=%s status=%s", r.Addr, r.Status.String())
+			}
+		}
+		if cerr := prober.EvaluateQuorum(results, flQuorum); cerr != nil {
+			log.Printf("error: %s", cerr.Error())
+			os.Exit(cerr.ExitCode)
+		}
+		log.Printf("status: quorum=%s satisfied", flQuorum)
+		return
+	}
+
+	if flWatch {
+		if cerr := checker.Watch(ctx, flWatchDuration); cerr != nil {
+			log.Printf("error: %s", cerr.Error())
+			os.Exit(cerr.ExitCode)
+		}
+		log.Printf("status: SERVING (watched for %v)", flWatchDuration)
+		return
+	}
+
+	resp, cerr := checker.Check(ctx)
+	if cerr != nil {
+		log.Printf("error: %s", cerr.Error())
+		os.Exit(cerr.ExitCode)
+	}
+	log.Printf("status: %v", resp.GetStatus())
+}
@@ -0,0 +1,176 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Command grpc-health-probe-retry exposes internal/probe's retrying Check
+// (CheckWithRetry) and streaming Watch as a standalone binary, for probe
+// modes that need to tolerate transient failures or wait for a dependency to
+// become SERVING without the caller having to fork a -watch loop itself.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"log"
+	"os"
+	"time"
+
+	"google.golang.org/grpc/credentials"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+
+	"github.com/grpc-ecosystem/grpc-health-probe/internal/probe"
+)
+
+const (
+	// statusInvalidArguments indicates specified invalid arguments.
+	statusInvalidArguments = 1
+	// statusConnectionFailure indicates connection failed.
+	statusConnectionFailure = 2
+	// statusRPCFailure indicates rpc failed.
+	statusRPCFailure = 3
+	// statusUnhealthy indicates rpc succeeded but indicates unhealthy service.
+	statusUnhealthy = 4
+)
+
+var (
+	flAddr             string
+	flService          string
+	flConnTimeout      time.Duration
+	flRPCTimeout       time.Duration
+	flTLS              bool
+	flTLSNoVerify      bool
+	flTLSCACert        string
+	flTLSClientCert    string
+	flTLSClientKey     string
+	flTLSServerName    string
+	flVerbose          bool
+	flRetryMax         int
+	flRetryInterval    time.Duration
+	flRetryMaxInterval time.Duration
+	flRetryExponential bool
+	flRetryMinSuccess  int
+	flWatch            bool
+	flWatchDuration    time.Duration
+)
+
+func parseFlags() {
+	flag.StringVar(&flAddr, "addr", "", "(required) tcp host:port to connect")
+	flag.StringVar(&flService, "service", "", "service name to check (default: \"\")")
+	flag.DurationVar(&flConnTimeout, "connect-timeout", time.Second, "timeout for establishing connection")
+	flag.DurationVar(&flRPCTimeout, "rpc-timeout", time.Second, "timeout for each health check rpc")
+	flag.BoolVar(&flTLS, "tls", false, "use TLS (default: false, INSECURE plaintext transport)")
+	flag.BoolVar(&flTLSNoVerify, "tls-no-verify", false, "(with -tls) don't verify the certificate (INSECURE) presented by the server")
+	flag.StringVar(&flTLSCACert, "tls-ca-cert", "", "(with -tls, optional) file containing trusted certificates for verifying server")
+	flag.StringVar(&flTLSClientCert, "tls-client-cert", "", "(with -tls, optional) client certificate for authenticating to the server (requires -tls-client-key)")
+	flag.StringVar(&flTLSClientKey, "tls-client-key", "", "(with -tls) client private key for authenticating to the server (requires -tls-client-cert)")
+	flag.StringVar(&flTLSServerName, "tls-server-name", "", "(with -tls) override the hostname used to verify the server certificate")
+	flag.BoolVar(&flVerbose, "v", false, "verbose logs")
+	flag.IntVar(&flRetryMax, "retry-max", 0, "number of additional attempts after the first failed one (default: 0, no retries)")
+	flag.DurationVar(&flRetryInterval, "retry-interval", time.Second, "base delay between retry attempts")
+	flag.DurationVar(&flRetryMaxInterval, "retry-max-interval", 30*time.Second, "(with -retry-backoff-exponential) cap on the retry delay")
+	flag.BoolVar(&flRetryExponential, "retry-backoff-exponential", false, "double the retry delay (up to -retry-max-interval, with jitter) after every failed attempt instead of retrying at a fixed -retry-interval")
+	flag.IntVar(&flRetryMinSuccess, "retry-min-consecutive-successes", 1, "number of back-to-back SERVING results required before the probe succeeds")
+	flag.BoolVar(&flWatch, "watch", false, "use the streaming Health/Watch RPC instead of CheckWithRetry, exiting once -watch-duration elapses while still SERVING")
+	flag.DurationVar(&flWatchDuration, "watch-duration", 30*time.Second, "(with -watch) how long the server must keep reporting SERVING before the watch succeeds")
+	flag.Parse()
+
+	if flAddr == "" {
+		log.Print("error: -addr not specified")
+		os.Exit(statusInvalidArguments)
+	}
+	if flRetryMax < 0 {
+		log.Print("error: -retry-max must not be negative")
+		os.Exit(statusInvalidArguments)
+	}
+	if flRetryMinSuccess > flRetryMax+1 {
+		log.Printf("error: -retry-min-consecutive-successes (%d) can never be reached within -retry-max+1 (%d) attempts", flRetryMinSuccess, flRetryMax+1)
+		os.Exit(statusInvalidArguments)
+	}
+}
+
+func buildCredentials() (credentials.TransportCredentials, error) {
+	if !flTLS {
+		return nil, nil
+	}
+	creds, err := probe.BuildCredentials(flTLSNoVerify, flTLSCACert, flTLSClientCert, flTLSClientKey, flTLSServerName)
+	if err != nil {
+		return nil, err
+	}
+	return creds, nil
+}
+
+func main() {
+	log.SetFlags(0)
+	parseFlags()
+
+	creds, err := buildCredentials()
+	if err != nil {
+		log.Printf("error: failed to initialize tls credentials. error=%v", err)
+		os.Exit(statusInvalidArguments)
+	}
+
+	ctx := context.Background()
+	conn, err := probe.Connect(ctx, flAddr, creds, flConnTimeout)
+	if err != nil {
+		log.Printf("error: %v", err)
+		os.Exit(statusConnectionFailure)
+	}
+	defer conn.Close()
+
+	client := healthpb.NewHealthClient(conn)
+
+	if flWatch {
+		cb := func(status healthpb.HealthCheckResponse_ServingStatus) {
+			if flVerbose {
+				log.Printf("watch: status=%v", status)
+			}
+		}
+		if err := probe.Watch(ctx, client, flWatchDuration, flService, cb); err != nil {
+			log.Printf("error: %v", err)
+			os.Exit(exitCodeFor(err))
+		}
+		log.Printf("status: SERVING (watched for %v)", flWatchDuration)
+		return
+	}
+
+	opts := probe.RetryOptions{
+		MaxRetries:              flRetryMax,
+		Interval:                flRetryInterval,
+		MaxInterval:             flRetryMaxInterval,
+		MinConsecutiveSuccesses: flRetryMinSuccess,
+	}
+	if flRetryExponential {
+		opts.Backoff = probe.BackoffExponential
+	}
+	if err := probe.CheckWithRetry(ctx, client, flRPCTimeout, flService, opts); err != nil {
+		log.Printf("error: %v", err)
+		os.Exit(exitCodeFor(err))
+	}
+	log.Print("status: SERVING")
+}
+
+// exitCodeFor maps an error returned by probe.Watch/probe.CheckWithRetry to
+// the same exit codes main.go's top-level probe uses, so scripts treating
+// this binary as a drop-in don't need to special-case it.
+func exitCodeFor(err error) int {
+	var unimplemented probe.UnimplementedError
+	if errors.As(err, &unimplemented) {
+		return statusRPCFailure
+	}
+	var servingStatus probe.ServingStatusError
+	if errors.As(err, &servingStatus) {
+		return statusUnhealthy
+	}
+	return statusRPCFailure
+}
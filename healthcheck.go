@@ -0,0 +1,64 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+// healthCheckResult is the outcome of a single Health/Check RPC, shared by
+// every probe mode (runProbe, checkOneService, probeServeTarget) so they
+// classify errors identically instead of each reimplementing it.
+type healthCheckResult struct {
+	Status    healthpb.HealthCheckResponse_ServingStatus
+	Err       string
+	RPCFailed bool
+	Duration  time.Duration
+}
+
+// doHealthCheck issues a Health/Check RPC for service against client using
+// rpcCtx (already carrying its own deadline and any outgoing metadata), and
+// classifies the outcome: Unimplemented and DeadlineExceeded get their own
+// wording, other RPC errors are reported verbatim, and a non-SERVING
+// response is treated as an error too (with RPCFailed left false, since the
+// RPC itself succeeded).
+func doHealthCheck(rpcCtx context.Context, client healthpb.HealthClient, service string, rpcTimeout time.Duration) healthCheckResult {
+	start := time.Now()
+	resp, err := client.Check(rpcCtx, &healthpb.HealthCheckRequest{Service: service})
+	duration := time.Since(start)
+	if err != nil {
+		result := healthCheckResult{RPCFailed: true, Duration: duration}
+		switch stat, ok := status.FromError(err); {
+		case ok && stat.Code() == codes.Unimplemented:
+			result.Err = fmt.Sprintf("this server does not implement the grpc health protocol (grpc.health.v1.Health): %s", stat.Message())
+		case ok && stat.Code() == codes.DeadlineExceeded:
+			result.Err = fmt.Sprintf("timeout: health rpc did not complete within %v", rpcTimeout)
+		default:
+			result.Err = fmt.Sprintf("health rpc failed: %+v", err)
+		}
+		return result
+	}
+
+	result := healthCheckResult{Status: resp.GetStatus(), Duration: duration}
+	if resp.GetStatus() != healthpb.HealthCheckResponse_SERVING {
+		result.Err = fmt.Sprintf("service unhealthy (responded with %q)", resp.GetStatus().String())
+	}
+	return result
+}
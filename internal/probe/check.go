@@ -31,6 +31,15 @@ func (s ServingStatusError) Error() string {
 		healthpb.HealthCheckResponse_ServingStatus(s).String())
 }
 
+// UnimplementedError indicates the server does not implement the grpc health
+// protocol (grpc.health.v1.Health) at all. Unlike a failing health check,
+// retrying is pointless: the RPC will never succeed against this server.
+type UnimplementedError struct{}
+
+func (UnimplementedError) Error() string {
+	return "error: this server does not implement the grpc health protocol (grpc.health.v1.Health)"
+}
+
 func Check(ctx context.Context, client healthpb.HealthClient, timeout time.Duration, serviceName string) error {
 	rpcCtx, cancel := context.WithTimeout(ctx, timeout)
 	defer cancel()
@@ -38,7 +47,7 @@ func Check(ctx context.Context, client healthpb.HealthClient, timeout time.Durat
 	resp, err := client.Check(rpcCtx, &healthpb.HealthCheckRequest{Service: serviceName})
 	if err != nil {
 		if stat, ok := status.FromError(err); ok && stat.Code() == codes.Unimplemented {
-			return fmt.Errorf("error: this server does not implement the grpc health protocol (grpc.health.v1.Health)")
+			return UnimplementedError{}
 		} else if stat, ok := status.FromError(err); ok && stat.Code() == codes.DeadlineExceeded {
 			return fmt.Errorf("timeout: health rpc did not complete within %v", timeout)
 		}
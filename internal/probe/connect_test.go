@@ -67,7 +67,7 @@ func TestConnect_withCredentials(t *testing.T) {
 }
 
 func TestConnect_withoutCredentials(t *testing.T) {
-	addr, close := makeServer(t, readCreds(t, testdata("127.0.0.1.pem"), testdata("127.0.0.1-key.pem")))
+	addr, close := makeServer(t)
 	defer close()
 
 	conn, err := Connect(context.TODO(), addr, nil, time.Millisecond*100)
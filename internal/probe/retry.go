@@ -0,0 +1,119 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package probe
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"time"
+
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// Backoff selects how the delay between retries grows.
+type Backoff int
+
+const (
+	// BackoffConstant retries at a fixed interval.
+	BackoffConstant Backoff = iota
+	// BackoffExponential doubles the interval after every failed attempt, up
+	// to MaxInterval, and jitters the result.
+	BackoffExponential
+)
+
+// RetryOptions configures CheckWithRetry.
+type RetryOptions struct {
+	// MaxRetries is the number of additional attempts after the first one.
+	// Zero means CheckWithRetry behaves like a single Check call.
+	MaxRetries int
+	// Interval is the base delay between attempts (and the fixed delay when
+	// Backoff is BackoffConstant).
+	Interval time.Duration
+	// MaxInterval caps the delay reached by exponential backoff.
+	MaxInterval time.Duration
+	// Backoff selects the growth strategy for the retry delay.
+	Backoff Backoff
+	// MinConsecutiveSuccesses is the number of back-to-back SERVING results
+	// required before CheckWithRetry returns success. Zero is treated as 1.
+	MinConsecutiveSuccesses int
+}
+
+// CheckWithRetry calls Check repeatedly until it has observed
+// MinConsecutiveSuccesses consecutive successes, a terminal error occurs, the
+// retry budget is exhausted, or ctx is done. Connection/RPC failures and
+// NOT_SERVING/SERVICE_UNKNOWN responses are retryable; an UnimplementedError
+// is terminal since retrying can never succeed against that server.
+func CheckWithRetry(ctx context.Context, client healthpb.HealthClient, timeout time.Duration, serviceName string, opts RetryOptions) error {
+	minSuccesses := opts.MinConsecutiveSuccesses
+	if minSuccesses <= 0 {
+		minSuccesses = 1
+	}
+
+	consecutiveSuccesses := 0
+	var lastErr error
+	for attempt := 0; attempt <= opts.MaxRetries; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-time.After(retryDelay(opts, attempt)):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+
+		err := Check(ctx, client, timeout, serviceName)
+		if err == nil {
+			consecutiveSuccesses++
+			if consecutiveSuccesses >= minSuccesses {
+				return nil
+			}
+			continue
+		}
+
+		consecutiveSuccesses = 0
+		lastErr = err
+		var unimplemented UnimplementedError
+		if errors.As(err, &unimplemented) {
+			return err
+		}
+	}
+	if lastErr != nil {
+		return lastErr
+	}
+	// Every attempt in the retry budget succeeded, but it was too small to
+	// ever reach minSuccesses consecutive successes (e.g. MaxRetries=0 with
+	// MinConsecutiveSuccesses>1): report that instead of silently returning
+	// success after consecutiveSuccesses consecutive ones.
+	return fmt.Errorf("retry budget exhausted after %d consecutive successes, want %d", consecutiveSuccesses, minSuccesses)
+}
+
+// retryDelay computes the delay before the given attempt (1-indexed).
+func retryDelay(opts RetryOptions, attempt int) time.Duration {
+	if opts.Backoff != BackoffExponential {
+		return opts.Interval
+	}
+
+	delay := opts.Interval
+	for i := 1; i < attempt; i++ {
+		delay *= 2
+		if opts.MaxInterval > 0 && delay > opts.MaxInterval {
+			delay = opts.MaxInterval
+			break
+		}
+	}
+	// Full jitter: uniformly distribute in [0, delay].
+	return time.Duration(rand.Int63n(int64(delay) + 1))
+}
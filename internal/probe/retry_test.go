@@ -0,0 +1,55 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package probe
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+func TestCheckWithRetry_budgetTooSmallForMinSuccessesFails(t *testing.T) {
+	c := &mockHealthClient{
+		checkFunc: func(string) (healthpb.HealthCheckResponse_ServingStatus, error) {
+			return healthpb.HealthCheckResponse_SERVING, nil
+		},
+	}
+
+	err := CheckWithRetry(context.Background(), c, time.Second, "", RetryOptions{
+		MaxRetries:              0,
+		MinConsecutiveSuccesses: 3,
+	})
+	if err == nil {
+		t.Fatal("CheckWithRetry succeeded despite never reaching MinConsecutiveSuccesses within the retry budget")
+	}
+}
+
+func TestCheckWithRetry_reachesMinSuccessesWithinBudget(t *testing.T) {
+	c := &mockHealthClient{
+		checkFunc: func(string) (healthpb.HealthCheckResponse_ServingStatus, error) {
+			return healthpb.HealthCheckResponse_SERVING, nil
+		},
+	}
+
+	err := CheckWithRetry(context.Background(), c, time.Second, "", RetryOptions{
+		MaxRetries:              2,
+		MinConsecutiveSuccesses: 3,
+	})
+	if err != nil {
+		t.Fatalf("CheckWithRetry failed despite enough budget to reach MinConsecutiveSuccesses: %v", err)
+	}
+}
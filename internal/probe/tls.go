@@ -0,0 +1,59 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package probe
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+
+	"google.golang.org/grpc/credentials"
+)
+
+// BuildCredentials assembles TLS transport credentials for Connect from the
+// same set of inputs main.go's -tls* flags expose: an optional client
+// cert/key pair, an optional CA bundle overriding the system roots, and an
+// optional server name override.
+func BuildCredentials(skipVerify bool, caCerts, clientCert, clientKey, serverName string) (credentials.TransportCredentials, error) {
+	var cfg tls.Config
+
+	if clientCert != "" && clientKey != "" {
+		keyPair, err := tls.LoadX509KeyPair(clientCert, clientKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load tls client cert/key pair. error=%v", err)
+		}
+		cfg.Certificates = []tls.Certificate{keyPair}
+	}
+
+	if skipVerify {
+		cfg.InsecureSkipVerify = true
+	} else if caCerts != "" {
+		// override system roots
+		rootCAs := x509.NewCertPool()
+		pem, err := ioutil.ReadFile(caCerts)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load root CA certificates from file (%s) error=%v", caCerts, err)
+		}
+		if !rootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no root CA certs parsed from file %s", caCerts)
+		}
+		cfg.RootCAs = rootCAs
+	}
+	if serverName != "" {
+		cfg.ServerName = serverName
+	}
+	return credentials.NewTLS(&cfg), nil
+}
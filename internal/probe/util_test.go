@@ -63,6 +63,29 @@ func makeRequest(t *testing.T, conn *grpc.ClientConn) {
 	}
 }
 
+// mockHealthClient is a minimal healthpb.HealthClient whose Check behavior
+// is supplied per test via checkFunc, so the error-classification tests in
+// check_test.go don't need a real gRPC server/client pair.
+type mockHealthClient struct {
+	checkFunc func(service string) (healthpb.HealthCheckResponse_ServingStatus, error)
+}
+
+func (m *mockHealthClient) Check(ctx context.Context, in *healthpb.HealthCheckRequest, opts ...grpc.CallOption) (*healthpb.HealthCheckResponse, error) {
+	status, err := m.checkFunc(in.GetService())
+	if err != nil {
+		return nil, err
+	}
+	return &healthpb.HealthCheckResponse{Status: status}, nil
+}
+
+func (m *mockHealthClient) List(ctx context.Context, in *healthpb.HealthListRequest, opts ...grpc.CallOption) (*healthpb.HealthListResponse, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (m *mockHealthClient) Watch(ctx context.Context, in *healthpb.HealthCheckRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[healthpb.HealthCheckResponse], error) {
+	return nil, errors.New("not implemented")
+}
+
 type mockHealth struct{}
 
 func (m *mockHealth) Check(ctx context.Context, req *healthpb.HealthCheckRequest) (*healthpb.HealthCheckResponse, error) {
@@ -73,6 +96,10 @@ func (m *mockHealth) Watch(*healthpb.HealthCheckRequest, healthpb.Health_WatchSe
 	return errors.New("not implemented")
 }
 
+func (m *mockHealth) List(ctx context.Context, req *healthpb.HealthListRequest) (*healthpb.HealthListResponse, error) {
+	return nil, errors.New("not implemented")
+}
+
 func newMockHealthService() healthpb.HealthServer {
 	return &mockHealth{}
 }
@@ -0,0 +1,80 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package probe
+
+import (
+	"context"
+	"fmt"
+
+	"time"
+
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+// WatchFunc is invoked with every serving status reported on a Watch stream,
+// including the initial one.
+type WatchFunc func(status healthpb.HealthCheckResponse_ServingStatus)
+
+// Watch opens the streaming Health.Watch RPC for serviceName and calls cb for
+// every status update the server sends. It returns a ServingStatusError as
+// soon as the server reports NOT_SERVING or SERVICE_UNKNOWN, or a plain error
+// if the stream itself fails. If deadline elapses while the last reported
+// status was SERVING, Watch returns nil so that callers can block a readiness
+// gate until a dependency comes up without busy-looping the process.
+func Watch(ctx context.Context, client healthpb.HealthClient, deadline time.Duration, serviceName string, cb WatchFunc) error {
+	watchCtx, cancel := context.WithTimeout(ctx, deadline)
+	defer cancel()
+
+	stream, err := client.Watch(watchCtx, &healthpb.HealthCheckRequest{Service: serviceName})
+	if err != nil {
+		return fmt.Errorf("error: failed to open watch stream: %+v", err)
+	}
+	return RunWatchLoop(watchCtx, stream, cb)
+}
+
+// RunWatchLoop reads status updates from stream until it ends, invoking cb
+// for every one (including the initial status), and applies the Watch
+// contract shared by every Watch implementation in this repo
+// (internal/probe, pkg/prober's (*checker).Watch, and main.go's -stream
+// mode): NOT_SERVING/SERVICE_UNKNOWN is a failure, returned as a
+// ServingStatusError; a server that doesn't implement the Watch RPC at all
+// fails with UnimplementedError, matching Check; and the stream ending
+// because ctx's own deadline elapsed while the last reported status was
+// SERVING is success (nil).
+func RunWatchLoop(ctx context.Context, stream healthpb.Health_WatchClient, cb WatchFunc) error {
+	lastStatus := healthpb.HealthCheckResponse_UNKNOWN
+	for {
+		resp, err := stream.Recv()
+		if err != nil {
+			if ctx.Err() != nil && lastStatus == healthpb.HealthCheckResponse_SERVING {
+				return nil
+			}
+			if stat, ok := status.FromError(err); ok && stat.Code() == codes.Unimplemented {
+				return UnimplementedError{}
+			}
+			return fmt.Errorf("error: watch stream failed: %+v", err)
+		}
+		lastStatus = resp.GetStatus()
+		if cb != nil {
+			cb(lastStatus)
+		}
+		switch lastStatus {
+		case healthpb.HealthCheckResponse_NOT_SERVING, healthpb.HealthCheckResponse_SERVICE_UNKNOWN:
+			return ServingStatusError(lastStatus)
+		}
+	}
+}
@@ -0,0 +1,46 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package probe
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+func TestWatch_unimplementedServer(t *testing.T) {
+	lis, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %+v", err)
+	}
+	// No Health service registered: the server rejects Watch with
+	// codes.Unimplemented, same as it would for Check.
+	srv := grpc.NewServer()
+	go srv.Serve(lis)
+	defer srv.Stop()
+
+	conn := connect(t, lis.Addr().String(), grpc.WithInsecure())
+	defer conn.Close()
+
+	err = Watch(context.Background(), healthpb.NewHealthClient(conn), time.Second, "", nil)
+	var unimplemented UnimplementedError
+	if !errors.As(err, &unimplemented) {
+		t.Fatalf("Watch against a server with no Health service returned %v, want an UnimplementedError", err)
+	}
+}
@@ -4,7 +4,7 @@
 // you may not use this file except in compliance with the License.
 // You may obtain a copy of the License at
 //
-//      http://www.apache.org/licenses/LICENSE-2.0
+//	http://www.apache.org/licenses/LICENSE-2.0
 //
 // Unless required by applicable law or agreed to in writing, software
 // distributed under the License is distributed on an "AS IS" BASIS,
@@ -17,44 +17,77 @@ import (
 	"context"
 	"crypto/tls"
 	"crypto/x509"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"io/ioutil"
 	"log"
+	"math/rand"
 	"os"
 	"os/signal"
 	"strings"
+	"sync"
 	"time"
 	"unicode"
 
 	"github.com/spiffe/go-spiffe/v2/spiffetls/tlsconfig"
 	"github.com/spiffe/go-spiffe/v2/workloadapi"
 	"google.golang.org/grpc"
-	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/credentials/alts"
+	"google.golang.org/grpc/credentials/insecure"
+	xdscreds "google.golang.org/grpc/credentials/xds"
 	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 	"google.golang.org/grpc/metadata"
-	"google.golang.org/grpc/status"
+	_ "google.golang.org/grpc/xds" // register the xds:/// target resolver
+
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"go.opentelemetry.io/otel/trace"
 )
 
 var (
-	flAddr          string
-	flService       string
-	flUserAgent     string
-	flConnTimeout   time.Duration
-	flRPCHeaders    = rpcHeaders{MD: make(metadata.MD)}
-	flRPCTimeout    time.Duration
-	flTLS           bool
-	flTLSNoVerify   bool
-	flTLSCACert     string
-	flTLSClientCert string
-	flTLSClientKey  string
-	flTLSServerName string
-	flALTS          bool
-	flVerbose       bool
-	flGZIP          bool
-	flSPIFFE        bool
+	flAddr                   string
+	flService                string
+	flUserAgent              string
+	flConnTimeout            time.Duration
+	flRPCHeaders             = rpcHeaders{MD: make(metadata.MD)}
+	flRPCTimeout             time.Duration
+	flTLS                    bool
+	flTLSNoVerify            bool
+	flTLSCACert              string
+	flTLSClientCert          string
+	flTLSClientKey           string
+	flTLSServerName          string
+	flTLSCertRefreshInterval time.Duration
+	flALTS                   bool
+	flVerbose                bool
+	flGZIP                   bool
+	flSPIFFE                 bool
+	flSpiffeAuthorize        spiffeMatchers
+	flAttestationReport      string
+	flWatch                  bool
+	flWatchInterval          time.Duration
+	flJSON                   bool
+	flStream                 bool
+	flStreamMaxDuration      time.Duration
+	flExitOnNotServing       bool
+	flOAuthToken             string
+	flOAuthTokenFile         string
+	flOAuthTokenExec         string
+	flGoogleADC              bool
+	flXDSBootstrap           string
+	flXDSCreds               bool
+	flMetricsFile            string
+	flPushgatewayURL         string
+	flPushgatewayJob         string
+	flPushgatewayLabels      = pushgatewayLabels{}
+	flOtelEndpoint           string
+	flOtelHeaders            = otelHeaders{}
+	flOtelInsecure           bool
+	flOtelServiceName        string
+	flServices               = serviceList{}
+	flRequire                string
 )
 
 const (
@@ -71,8 +104,21 @@ const (
 )
 
 func init() {
-	flagSet := flag.NewFlagSet("", flag.ContinueOnError)
 	log.SetFlags(0)
+
+	if len(os.Args) > 1 && os.Args[1] == "serve" {
+		flServeMode = true
+		parseServeArgs(os.Args[2:])
+	}
+}
+
+// parseArgs parses args as the probe's command-line flags into the
+// package-level fl* globals, using a fresh FlagSet so repeated calls (from
+// probe, in tests) don't collide with flag registrations from a previous
+// call. It returns 0 if args are valid, or a non-zero Status* code (having
+// already logged the reason) otherwise.
+func parseArgs(args []string) int {
+	flagSet := flag.NewFlagSet("", flag.ContinueOnError)
 	flagSet.StringVar(&flAddr, "addr", "", "(required) tcp host:port to connect")
 	flagSet.StringVar(&flService, "service", "", "service name to check (default: \"\")")
 	flagSet.StringVar(&flUserAgent, "user-agent", "grpc_health_probe", "user-agent header value of health check requests")
@@ -87,59 +133,147 @@ func init() {
 	flagSet.StringVar(&flTLSClientCert, "tls-client-cert", "", "(with -tls, optional) client certificate for authenticating to the server (requires -tls-client-key)")
 	flagSet.StringVar(&flTLSClientKey, "tls-client-key", "", "(with -tls) client private key for authenticating to the server (requires -tls-client-cert)")
 	flagSet.StringVar(&flTLSServerName, "tls-server-name", "", "(with -tls) override the hostname used to verify the server certificate")
+	flagSet.DurationVar(&flTLSCertRefreshInterval, "tls-cert-refresh-interval", 0, "(with -tls, optional) if set to a positive duration, reload the client cert/key pair and CA bundle from disk on each TLS handshake if their mtime has changed since last loaded (for a one-shot probe this is just the initial load; it takes effect across handshakes in -watch/-stream mode)")
 	flagSet.BoolVar(&flALTS, "alts", false, "use ALTS (default: false, INSECURE plaintext transport)")
 	flagSet.BoolVar(&flVerbose, "v", false, "verbose logs")
 	flagSet.BoolVar(&flGZIP, "gzip", false, "use GZIPCompressor for requests and GZIPDecompressor for response (default: false)")
 	flagSet.BoolVar(&flSPIFFE, "spiffe", false, "use SPIFFE to obtain mTLS credentials")
+	flagSet.Var(&flSpiffeAuthorize, "spiffe-authorize", "(with -spiffe, optional) allowed server SPIFFE ID or \"spiffe://<trust-domain>/*\" wildcard; may be specified more than once (default: accept any SPIFFE ID)")
+	flagSet.StringVar(&flAttestationReport, "attestation-report", "", "(with -tls, optional) name of a registered AttestationVerifier to check confidential-computing attestation evidence presented by the server")
+	flagSet.BoolVar(&flWatch, "watch", false, "keep running, probing every -watch-interval instead of exiting after one check")
+	flagSet.DurationVar(&flWatchInterval, "watch-interval", 10*time.Second, "(with -watch) interval between probes; failures back off exponentially (capped at 30s) with jitter, reset on success")
+	flagSet.BoolVar(&flJSON, "json", false, "emit probe results as one JSON object per line instead of human-readable log lines")
+	flagSet.BoolVar(&flStream, "stream", false, "use the streaming Health/Watch RPC instead of Check, logging every status transition the server pushes")
+	flagSet.DurationVar(&flStreamMaxDuration, "stream-max-duration", 0, "(with -stream, optional) maximum lifetime of the watch stream; zero means no limit")
+	flagSet.BoolVar(&flExitOnNotServing, "exit-on-not-serving", false, "(with -stream) exit with StatusUnhealthy the first time the server reports anything other than SERVING")
+	flagSet.StringVar(&flOAuthToken, "oauth-token", "", "(requires a secure transport) static bearer token attached to every RPC as per-RPC credentials")
+	flagSet.StringVar(&flOAuthTokenFile, "oauth-token-file", "", "(requires a secure transport) like -oauth-token, but reads the token from a file, reloaded whenever its mtime changes")
+	flagSet.StringVar(&flOAuthTokenExec, "oauth-token-exec", "", "(requires a secure transport) like -oauth-token, but obtains the token by running this command and using its trimmed stdout")
+	flagSet.BoolVar(&flGoogleADC, "google-adc", false, "(requires a secure transport) authenticate using Google Application Default Credentials, e.g. for Cloud Run or managed Anthos services")
+	flagSet.StringVar(&flXDSBootstrap, "xds-bootstrap", "", "path to the xDS bootstrap JSON file (falls back to GRPC_XDS_BOOTSTRAP/GRPC_XDS_BOOTSTRAP_CONFIG); required for -addr targets using the xds:/// scheme")
+	flagSet.BoolVar(&flXDSCreds, "xds-creds", false, "use transport credentials (e.g. mTLS via SDS) pushed by the xDS control plane instead of -tls/-alts/-spiffe")
+	flagSet.StringVar(&flMetricsFile, "metrics-file", "", "after each probe, atomically write Prometheus textfile-collector metrics to this path")
+	flagSet.StringVar(&flPushgatewayURL, "pushgateway-url", "", "after each probe, push Prometheus metrics to this Pushgateway base URL")
+	flagSet.StringVar(&flPushgatewayJob, "pushgateway-job", "grpc_health_probe", "(with -pushgateway-url) job label to push metrics under")
+	flagSet.Var(&flPushgatewayLabels, "pushgateway-label", "(with -pushgateway-url) additional 'name=value' grouping label; may be specified more than once")
+	flagSet.StringVar(&flOtelEndpoint, "otel-endpoint", "", "OTLP/gRPC collector endpoint (host:port); when set, the dial and health RPC are traced and probe.status/rpc.client.duration metrics are exported")
+	flagSet.Var(&flOtelHeaders, "otel-headers", "(with -otel-endpoint) additional 'name=value' header sent with every OTLP export; may be specified more than once")
+	flagSet.BoolVar(&flOtelInsecure, "otel-insecure", false, "(with -otel-endpoint) connect to the collector without TLS")
+	flagSet.StringVar(&flOtelServiceName, "otel-service-name", "grpc_health_probe", "(with -otel-endpoint) service.name resource attribute reported to the collector")
+	flagSet.Var(&flServices, "services", "comma-separated or repeatable list of service names to check in parallel over one connection; overrides -service")
+	flagSet.StringVar(&flRequire, "require", "all", "(with -services) how per-service results combine into the exit code: \"all\", \"any\", or \"majority\"")
 
-	err := flagSet.Parse(os.Args[1:])
-	if err != nil {
-		os.Exit(StatusInvalidArguments)
+	if err := flagSet.Parse(args); err != nil {
+		return StatusInvalidArguments
 	}
 
-	argError := func(s string, v ...interface{}) {
+	argError := func(s string, v ...interface{}) int {
 		log.Printf("error: "+s, v...)
-		os.Exit(StatusInvalidArguments)
+		return StatusInvalidArguments
 	}
 
 	if flAddr == "" {
-		argError("-addr not specified")
+		return argError("-addr not specified")
 	}
 	if flConnTimeout <= 0 {
-		argError("-connect-timeout must be greater than zero (specified: %v)", flConnTimeout)
+		return argError("-connect-timeout must be greater than zero (specified: %v)", flConnTimeout)
 	}
 	if flRPCTimeout <= 0 {
-		argError("-rpc-timeout must be greater than zero (specified: %v)", flRPCTimeout)
+		return argError("-rpc-timeout must be greater than zero (specified: %v)", flRPCTimeout)
 	}
 	if flALTS && flSPIFFE {
-		argError("-alts and -spiffe are mutually incompatible")
+		return argError("-alts and -spiffe are mutually incompatible")
 	}
 	if flTLS && flALTS {
-		argError("cannot specify -tls with -alts")
+		return argError("cannot specify -tls with -alts")
+	}
+	if flXDSCreds && (flTLS || flALTS || flSPIFFE) {
+		return argError("-xds-creds is mutually exclusive with -tls, -alts and -spiffe")
+	}
+	if flXDSBootstrap != "" {
+		if err := os.Setenv("GRPC_XDS_BOOTSTRAP", flXDSBootstrap); err != nil {
+			return argError("failed to set GRPC_XDS_BOOTSTRAP: %v", err)
+		}
+	}
+	if flPushgatewayURL == "" && len(flPushgatewayLabels) > 0 {
+		return argError("specified -pushgateway-label without specifying -pushgateway-url")
+	}
+	if flOtelEndpoint == "" && len(flOtelHeaders) > 0 {
+		return argError("specified -otel-headers without specifying -otel-endpoint")
+	}
+	if flOtelEndpoint == "" && flOtelInsecure {
+		return argError("specified -otel-insecure without specifying -otel-endpoint")
+	}
+	if len(flServices) > 0 && flService != "" {
+		return argError("cannot specify both -service and -services")
+	}
+	switch flRequire {
+	case "all", "any", "majority":
+	default:
+		return argError("invalid -require %q: must be \"all\", \"any\", or \"majority\"", flRequire)
+	}
+	if len(flServices) == 0 && flRequire != "all" {
+		return argError("specified -require without specifying -services")
 	}
 	if !flTLS && flTLSNoVerify {
-		argError("specified -tls-no-verify without specifying -tls")
+		return argError("specified -tls-no-verify without specifying -tls")
 	}
 	if !flTLS && flTLSCACert != "" {
-		argError("specified -tls-ca-cert without specifying -tls")
+		return argError("specified -tls-ca-cert without specifying -tls")
 	}
 	if !flTLS && flTLSClientCert != "" {
-		argError("specified -tls-client-cert without specifying -tls")
+		return argError("specified -tls-client-cert without specifying -tls")
 	}
 	if !flTLS && flTLSServerName != "" {
-		argError("specified -tls-server-name without specifying -tls")
+		return argError("specified -tls-server-name without specifying -tls")
 	}
 	if flTLSClientCert != "" && flTLSClientKey == "" {
-		argError("specified -tls-client-cert without specifying -tls-client-key")
+		return argError("specified -tls-client-cert without specifying -tls-client-key")
 	}
 	if flTLSClientCert == "" && flTLSClientKey != "" {
-		argError("specified -tls-client-key without specifying -tls-client-cert")
+		return argError("specified -tls-client-key without specifying -tls-client-cert")
 	}
 	if flTLSNoVerify && flTLSCACert != "" {
-		argError("cannot specify -tls-ca-cert with -tls-no-verify (CA cert would not be used)")
+		return argError("cannot specify -tls-ca-cert with -tls-no-verify (CA cert would not be used)")
 	}
 	if flTLSNoVerify && flTLSServerName != "" {
-		argError("cannot specify -tls-server-name with -tls-no-verify (server name would not be used)")
+		return argError("cannot specify -tls-server-name with -tls-no-verify (server name would not be used)")
+	}
+	if !flTLS && flTLSCertRefreshInterval > 0 {
+		return argError("specified -tls-cert-refresh-interval without specifying -tls")
+	}
+	if !flSPIFFE && len(flSpiffeAuthorize) > 0 {
+		return argError("specified -spiffe-authorize without specifying -spiffe")
+	}
+	if !flTLS && flAttestationReport != "" {
+		return argError("specified -attestation-report without specifying -tls")
+	}
+	if flWatchInterval <= 0 {
+		return argError("-watch-interval must be greater than zero (specified: %v)", flWatchInterval)
+	}
+	if flWatch && flStream {
+		return argError("-watch and -stream are mutually incompatible")
+	}
+	if !flStream && flStreamMaxDuration > 0 {
+		return argError("specified -stream-max-duration without specifying -stream")
+	}
+	if !flStream && flExitOnNotServing {
+		return argError("specified -exit-on-not-serving without specifying -stream")
+	}
+	if flStream && len(flServices) > 0 {
+		return argError("-services is not supported with -stream")
+	}
+	perRPCCredSources := 0
+	for _, set := range []bool{flOAuthToken != "", flOAuthTokenFile != "", flOAuthTokenExec != "", flGoogleADC} {
+		if set {
+			perRPCCredSources++
+		}
+	}
+	if perRPCCredSources > 1 {
+		return argError("-oauth-token, -oauth-token-file, -oauth-token-exec and -google-adc are mutually exclusive")
+	}
+	if perRPCCredSources > 0 && !flTLS && !flALTS && !flSPIFFE && !flXDSCreds {
+		return argError("per-RPC credentials (-oauth-token*, -google-adc) require -tls, -alts, -spiffe or -xds-creds")
 	}
 
 	if flVerbose {
@@ -155,10 +289,27 @@ func init() {
 			log.Printf("  > client-cert=%s", flTLSClientCert)
 			log.Printf("  > client-key=%s", flTLSClientKey)
 			log.Printf("  > server-name=%s", flTLSServerName)
+			log.Printf("  > cert-refresh-interval=%v", flTLSCertRefreshInterval)
+			log.Printf("  > attestation-report=%s", flAttestationReport)
 		}
 		log.Printf("> alts=%v", flALTS)
 		log.Printf("> spiffe=%v", flSPIFFE)
+		if flSPIFFE && len(flSpiffeAuthorize) > 0 {
+			log.Printf("  > spiffe-authorize=%s", flSpiffeAuthorize.String())
+		}
+		log.Printf("> watch=%v watch_interval=%v json=%v", flWatch, flWatchInterval, flJSON)
+		log.Printf("> stream=%v stream_max_duration=%v exit_on_not_serving=%v", flStream, flStreamMaxDuration, flExitOnNotServing)
+		if perRPCCredSources > 0 {
+			log.Printf("> per-rpc credentials: oauth-token=%v oauth-token-file=%s oauth-token-exec=%s google-adc=%v", flOAuthToken != "", flOAuthTokenFile, flOAuthTokenExec, flGoogleADC)
+		}
+		log.Printf("> xds-creds=%v xds-bootstrap=%s", flXDSCreds, flXDSBootstrap)
+		log.Printf("> metrics-file=%s pushgateway-url=%s pushgateway-job=%s", flMetricsFile, flPushgatewayURL, flPushgatewayJob)
+		log.Printf("> otel-endpoint=%s otel-insecure=%v otel-service-name=%s", flOtelEndpoint, flOtelInsecure, flOtelServiceName)
+		if len(flServices) > 0 {
+			log.Printf("> services=%s require=%s", flServices, flRequire)
+		}
 	}
+	return 0
 }
 
 type rpcHeaders struct{ metadata.MD }
@@ -175,7 +326,7 @@ func (s *rpcHeaders) Set(value string) error {
 	return nil
 }
 
-func buildCredentials(skipVerify bool, caCerts, clientCert, clientKey, serverName string) (credentials.TransportCredentials, error) {
+func buildCredentials(skipVerify bool, caCerts, clientCert, clientKey, serverName string, attestationVerifier AttestationVerifier) (credentials.TransportCredentials, error) {
 	var cfg tls.Config
 
 	if clientCert != "" && clientKey != "" {
@@ -203,53 +354,82 @@ func buildCredentials(skipVerify bool, caCerts, clientCert, clientKey, serverNam
 	if serverName != "" {
 		cfg.ServerName = serverName
 	}
+	if attestationVerifier != nil {
+		applyAttestation(&cfg, attestationVerifier)
+	}
 	return credentials.NewTLS(&cfg), nil
 }
 
-func main() {
-	retcode := 0
-	defer func() { os.Exit(retcode) }()
-
-	ctx, cancel := context.WithCancel(context.Background())
+var (
+	spiffeSourceOnce sync.Once
+	spiffeSource     *workloadapi.X509Source
+	spiffeSourceErr  error
+)
 
-	c := make(chan os.Signal, 1)
-	signal.Notify(c, os.Interrupt)
-	go func() {
-		sig := <-c
-		if sig == os.Interrupt {
-			log.Printf("cancellation received")
-			cancel()
-			return
-		}
-	}()
+// getSpiffeSource lazily builds, and caches for the lifetime of the process,
+// the Workload API X.509 source used for -spiffe credentials. buildDialOptions
+// is called on every request in -serve mode, and workloadapi.NewX509Source
+// opens a connection to the Workload API that must be explicitly Close()'d;
+// without caching, each request would leak one. ctx is only consulted on the
+// first call, to wait for the initial Workload API update.
+func getSpiffeSource(ctx context.Context) (*workloadapi.X509Source, error) {
+	spiffeSourceOnce.Do(func() {
+		spiffeCtx, spiffeCancel := context.WithTimeout(ctx, flRPCTimeout)
+		defer spiffeCancel()
+		spiffeSource, spiffeSourceErr = workloadapi.NewX509Source(spiffeCtx)
+	})
+	return spiffeSource, spiffeSourceErr
+}
 
+// buildDialOptions assembles the transport/per-call grpc.DialOptions implied
+// by the TLS/ALTS/SPIFFE/GZIP flags. It returns a non-zero retcode (and logs
+// the reason) if the requested credentials could not be initialized.
+func buildDialOptions(ctx context.Context) ([]grpc.DialOption, int) {
 	opts := []grpc.DialOption{
 		grpc.WithUserAgent(flUserAgent),
 		grpc.WithBlock(),
 	}
 	if flTLS && flSPIFFE {
 		log.Printf("-tls and -spiffe are mutually incompatible")
-		retcode = StatusInvalidArguments
-		return
+		return nil, StatusInvalidArguments
 	}
-	if flTLS {
-		creds, err := buildCredentials(flTLSNoVerify, flTLSCACert, flTLSClientCert, flTLSClientKey, flTLSServerName)
+	var attestationVerifier AttestationVerifier
+	if flAttestationReport != "" {
+		var err error
+		attestationVerifier, err = lookupAttestationVerifier(flAttestationReport)
 		if err != nil {
-			log.Printf("failed to initialize tls credentials. error=%v", err)
-			retcode = StatusInvalidArguments
-			return
+			log.Printf("failed to initialize attestation verifier. error=%v", err)
+			return nil, StatusInvalidArguments
+		}
+	}
+	if flXDSCreds {
+		creds, err := xdscreds.NewClientCredentials(xdscreds.ClientOptions{FallbackCreds: insecure.NewCredentials()})
+		if err != nil {
+			log.Printf("failed to initialize xds credentials. error=%v", err)
+			return nil, StatusInvalidArguments
+		}
+		opts = append(opts, grpc.WithTransportCredentials(creds))
+	} else if flTLS {
+		var creds credentials.TransportCredentials
+		if flTLSCertRefreshInterval > 0 {
+			creds = newReloadableCredentials(flTLSClientCert, flTLSClientKey, flTLSCACert, flTLSServerName, flTLSNoVerify, flAddr, attestationVerifier)
+		} else {
+			var err error
+			creds, err = buildCredentials(flTLSNoVerify, flTLSCACert, flTLSClientCert, flTLSClientKey, flTLSServerName, attestationVerifier)
+			if err != nil {
+				log.Printf("failed to initialize tls credentials. error=%v", err)
+				return nil, StatusInvalidArguments
+			}
 		}
 		opts = append(opts, grpc.WithTransportCredentials(creds))
 	} else if flALTS {
 		creds := alts.NewServerCreds(alts.DefaultServerOptions())
 		opts = append(opts, grpc.WithTransportCredentials(creds))
 	} else if flSPIFFE {
-		spiffeCtx, _ := context.WithTimeout(ctx, flRPCTimeout)
-		source, err := workloadapi.NewX509Source(spiffeCtx)
+		source, err := getSpiffeSource(ctx)
 		if err != nil {
 			log.Printf("failed to initialize tls credentials with spiffe. error=%v", err)
-			retcode = StatusSpiffeFailed
-			return
+			return nil, StatusSpiffeFailed
 		}
 		if flVerbose {
 			svid, err := source.GetX509SVID()
@@ -258,8 +438,19 @@ func main() {
 			}
 			log.Printf("SPIFFE Verifiable Identity Document (SVID): %q", svid.ID)
 		}
-		creds := credentials.NewTLS(tlsconfig.MTLSClientConfig(source, source, tlsconfig.AuthorizeAny()))
+		authorizer, err := buildSpiffeAuthorizer(flSpiffeAuthorize)
+		if err != nil {
+			log.Printf("failed to initialize spiffe authorizer. error=%v", err)
+			return nil, StatusSpiffeFailed
+		}
+		creds := credentials.NewTLS(tlsconfig.MTLSClientConfig(source, source, authorizer))
 		opts = append(opts, grpc.WithTransportCredentials(creds))
+		// Without these, a handshake rejected by authorizer just makes
+		// WithBlock retry until the dial context deadline, so runProbe's
+		// errors.As(err, &authzErr) below never matches and an unauthorized
+		// peer gets reported as a generic connection timeout instead of
+		// StatusSpiffeFailed.
+		opts = append(opts, grpc.FailOnNonTempDialError(true), grpc.WithReturnConnectionError())
 	} else {
 		opts = append(opts, grpc.WithInsecure())
 	}
@@ -271,21 +462,59 @@ func main() {
 		)
 	}
 
+	if flOtelEndpoint != "" {
+		opts = append(opts, grpc.WithStatsHandler(otelgrpc.NewClientHandler()))
+	}
+
+	perRPCCreds, err := buildPerRPCCredentials(ctx)
+	if err != nil {
+		log.Printf("failed to initialize per-rpc credentials. error=%v", err)
+		return nil, StatusInvalidArguments
+	}
+	if perRPCCreds != nil {
+		opts = append(opts, grpc.WithPerRPCCredentials(perRPCCreds))
+	}
+	return opts, 0
+}
+
+// probeResult is the outcome of a single connect+Check cycle, in a form
+// that's equally easy to log as text or emit as -json output.
+type probeResult struct {
+	Status  string        `json:"status"`
+	ConnMS  int64         `json:"conn_ms"`
+	RPCMS   int64         `json:"rpc_ms"`
+	Err     string        `json:"error,omitempty"`
+	Retcode int           `json:"-"`
+	connDur time.Duration `json:"-"`
+	rpcDur  time.Duration `json:"-"`
+}
+
+// runProbe dials flAddr with opts, issues a single Check, and returns the
+// outcome. It never calls log.Fatal/os.Exit so it can be called repeatedly
+// from a -watch loop as well as once from main.
+func runProbe(ctx context.Context, opts []grpc.DialOption) probeResult {
 	if flVerbose {
 		log.Print("establishing connection")
 	}
 	connStart := time.Now()
 	dialCtx, dialCancel := context.WithTimeout(ctx, flConnTimeout)
 	defer dialCancel()
+	if flOtelEndpoint != "" {
+		var dialSpan trace.Span
+		dialCtx, dialSpan = otelTracer.Start(dialCtx, "grpc_health_probe.Dial")
+		defer dialSpan.End()
+	}
 	conn, err := grpc.DialContext(dialCtx, flAddr, opts...)
 	if err != nil {
-		if err == context.DeadlineExceeded {
-			log.Printf("timeout: failed to connect service %q within %v", flAddr, flConnTimeout)
-		} else {
-			log.Printf("error: failed to connect service at %q: %+v", flAddr, err)
+		var authzErr *spiffeAuthorizationError
+		switch {
+		case errors.As(err, &authzErr):
+			return probeResult{Err: authzErr.Error(), Retcode: StatusSpiffeFailed}
+		case err == context.DeadlineExceeded:
+			return probeResult{Err: fmt.Sprintf("timeout: failed to connect service %q within %v", flAddr, flConnTimeout), Retcode: StatusConnectionFailure}
+		default:
+			return probeResult{Err: fmt.Sprintf("failed to connect service at %q: %+v", flAddr, err), Retcode: StatusConnectionFailure}
 		}
-		retcode = StatusConnectionFailure
-		return
 	}
 	connDuration := time.Since(connStart)
 	defer conn.Close()
@@ -293,33 +522,231 @@ func main() {
 		log.Printf("connection established (took %v)", connDuration)
 	}
 
-	rpcStart := time.Now()
 	rpcCtx, rpcCancel := context.WithTimeout(ctx, flRPCTimeout)
 	defer rpcCancel()
+	if flOtelEndpoint != "" {
+		var rpcSpan trace.Span
+		rpcCtx, rpcSpan = otelTracer.Start(rpcCtx, "grpc_health_probe.Check")
+		defer rpcSpan.End()
+	}
 	rpcCtx = metadata.NewOutgoingContext(rpcCtx, flRPCHeaders.MD)
-	resp, err := healthpb.NewHealthClient(conn).Check(rpcCtx,
-		&healthpb.HealthCheckRequest{
-			Service: flService})
-	if err != nil {
-		if stat, ok := status.FromError(err); ok && stat.Code() == codes.Unimplemented {
-			log.Printf("error: this server does not implement the grpc health protocol (grpc.health.v1.Health): %s", stat.Message())
-		} else if stat, ok := status.FromError(err); ok && stat.Code() == codes.DeadlineExceeded {
-			log.Printf("timeout: health rpc did not complete within %v", flRPCTimeout)
-		} else {
-			log.Printf("error: health rpc failed: %+v", err)
+	outcome := doHealthCheck(rpcCtx, healthpb.NewHealthClient(conn), flService, flRPCTimeout)
+
+	result := probeResult{
+		ConnMS:  connDuration.Milliseconds(),
+		RPCMS:   outcome.Duration.Milliseconds(),
+		Err:     outcome.Err,
+		connDur: connDuration,
+		rpcDur:  outcome.Duration,
+	}
+	if outcome.Err != "" {
+		result.Retcode = StatusRPCFailure
+		if !outcome.RPCFailed {
+			result.Retcode = StatusUnhealthy
+		}
+	} else {
+		result.Status = outcome.Status.String()
+	}
+	return result
+}
+
+// logProbe reports result as either a JSON line (-json) or the classic
+// human-readable log lines.
+func logProbe(result probeResult) {
+	if flJSON {
+		type jsonResult struct {
+			TS     string `json:"ts"`
+			Addr   string `json:"addr"`
+			Status string `json:"status"`
+			ConnMS int64  `json:"conn_ms"`
+			RPCMS  int64  `json:"rpc_ms"`
+			Err    string `json:"error,omitempty"`
 		}
-		retcode = StatusRPCFailure
+		out, err := json.Marshal(jsonResult{
+			TS:     time.Now().UTC().Format(time.RFC3339Nano),
+			Addr:   flAddr,
+			Status: result.Status,
+			ConnMS: result.ConnMS,
+			RPCMS:  result.RPCMS,
+			Err:    result.Err,
+		})
+		if err != nil {
+			log.Printf("error: failed to marshal -json probe result: %v", err)
+			return
+		}
+		fmt.Println(string(out))
 		return
 	}
-	rpcDuration := time.Since(rpcStart)
 
-	if resp.GetStatus() != healthpb.HealthCheckResponse_SERVING {
-		log.Printf("service unhealthy (responded with %q)", resp.GetStatus().String())
-		retcode = StatusUnhealthy
+	if result.Err != "" {
+		log.Printf("error: %s", result.Err)
 		return
 	}
 	if flVerbose {
-		log.Printf("time elapsed: connect=%v rpc=%v", connDuration, rpcDuration)
+		log.Printf("time elapsed: connect=%v rpc=%v", result.connDur, result.rpcDur)
+	}
+	log.Printf("status: %v", result.Status)
+}
+
+// reportMetrics writes result to -metrics-file and/or pushes it to
+// -pushgateway-url, if configured. Failures are logged but never change the
+// probe's own exit code: metrics delivery is best-effort observability, not
+// part of the health verdict.
+func reportMetrics(result probeResult) {
+	if flMetricsFile != "" {
+		if err := writeMetricsFile(flMetricsFile, result); err != nil {
+			log.Printf("error: %v", err)
+		}
+	}
+	if flPushgatewayURL != "" {
+		if err := pushMetrics(flPushgatewayURL, flPushgatewayJob, flPushgatewayLabels, result); err != nil {
+			log.Printf("error: %v", err)
+		}
+	}
+	if flOtelEndpoint != "" {
+		recordOtelMetrics(result)
+	}
+}
+
+// withCancelOnInterrupt returns a context that's canceled on SIGINT, and a
+// cleanup func that stops relaying signals into it. Shared by the serve and
+// one-shot/-watch/-stream probe paths, which each need their own context but
+// the same cancellation wiring.
+func withCancelOnInterrupt() (context.Context, func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, os.Interrupt)
+	go func() {
+		select {
+		case sig := <-c:
+			if sig == os.Interrupt {
+				log.Printf("cancellation received")
+				cancel()
+			}
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, func() {
+		signal.Stop(c)
+		cancel()
+	}
+}
+
+// probe parses args as the probe's command-line flags and runs the
+// resulting one-shot/-watch/-stream/-services check, returning the process
+// exit code it implies. It never calls os.Exit itself, so it doubles as the
+// entry point the test suite drives directly.
+func probe(args ...string) int {
+	if retcode := parseArgs(args); retcode != 0 {
+		return retcode
+	}
+
+	ctx, cleanup := withCancelOnInterrupt()
+	defer cleanup()
+
+	if flOtelEndpoint != "" {
+		shutdown, err := initOTel(ctx)
+		if err != nil {
+			log.Printf("error: failed to initialize otel: %v", err)
+		} else {
+			defer func() {
+				flushCtx, flushCancel := context.WithTimeout(context.Background(), 5*time.Second)
+				defer flushCancel()
+				if err := shutdown(flushCtx); err != nil {
+					log.Printf("error: failed to flush otel exporters: %v", err)
+				}
+			}()
+		}
+	}
+
+	if flStream {
+		return runStream(ctx)
+	}
+
+	opts, retcode := buildDialOptions(ctx)
+	if retcode != 0 {
+		return retcode
+	}
+
+	if len(flServices) > 0 {
+		if !flWatch {
+			result := runMultiProbe(ctx, opts, flServices)
+			logMultiProbe(result)
+			reportMetrics(result.Overall)
+			return result.Overall.Retcode
+		}
+
+		backoff := flWatchInterval
+		for {
+			result := runMultiProbe(ctx, opts, flServices)
+			logMultiProbe(result)
+			reportMetrics(result.Overall)
+			if result.Overall.Retcode == 0 {
+				backoff = flWatchInterval
+			} else {
+				backoff = nextWatchBackoff(backoff)
+			}
+
+			select {
+			case <-ctx.Done():
+				return 0
+			case <-time.After(jitterBackoff(backoff)):
+			}
+		}
+	}
+
+	if !flWatch {
+		result := runProbe(ctx, opts)
+		logProbe(result)
+		reportMetrics(result)
+		return result.Retcode
+	}
+
+	backoff := flWatchInterval
+	for {
+		result := runProbe(ctx, opts)
+		logProbe(result)
+		reportMetrics(result)
+		if result.Retcode == 0 {
+			backoff = flWatchInterval
+		} else {
+			backoff = nextWatchBackoff(backoff)
+		}
+
+		select {
+		case <-ctx.Done():
+			return 0
+		case <-time.After(jitterBackoff(backoff)):
+		}
 	}
-	log.Printf("status: %v", resp.GetStatus().String())
 }
+
+func main() {
+	if flServeMode {
+		ctx, cleanup := withCancelOnInterrupt()
+		defer cleanup()
+		os.Exit(runServe(ctx))
+	}
+
+	os.Exit(probe(os.Args[1:]...))
+}
+
+// nextWatchBackoff doubles the previous delay, capped at watchMaxBackoff.
+// Callers should keep the returned value as the un-jittered backoff and
+// pass it through jitterBackoff to get the actual sleep duration, so
+// repeated failures keep growing instead of being reset by jitter.
+func nextWatchBackoff(prev time.Duration) time.Duration {
+	next := prev * 2
+	if next > watchMaxBackoff {
+		next = watchMaxBackoff
+	}
+	return next
+}
+
+// jitterBackoff applies full jitter to a backoff so repeated failures
+// don't hammer a crashing server in lockstep with other probes.
+func jitterBackoff(backoff time.Duration) time.Duration {
+	return time.Duration(rand.Int63n(int64(backoff) + 1))
+}
+
+const watchMaxBackoff = 30 * time.Second
@@ -0,0 +1,118 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// pushgatewayLabels collects the values of repeated -pushgateway-label flags,
+// each in "name=value" form, used as the Pushgateway grouping key.
+type pushgatewayLabels map[string]string
+
+func (l pushgatewayLabels) String() string {
+	parts := make([]string, 0, len(l))
+	for k, v := range l {
+		parts = append(parts, fmt.Sprintf("%s=%s", k, v))
+	}
+	return strings.Join(parts, ",")
+}
+
+func (l pushgatewayLabels) Set(value string) error {
+	parts := strings.SplitN(value, "=", 2)
+	if len(parts) != 2 {
+		return fmt.Errorf("invalid -pushgateway-label, expected 'name=value', got %q", value)
+	}
+	l[parts[0]] = parts[1]
+	return nil
+}
+
+// renderMetrics formats result as Prometheus text-format metrics, in the
+// node_exporter textfile collector convention.
+func renderMetrics(result probeResult) string {
+	serving := 0
+	if result.Status == "SERVING" {
+		serving = 1
+	}
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "# HELP grpc_health_probe_status Whether the last probe reported SERVING (1) or not (0).\n")
+	fmt.Fprintf(&buf, "# TYPE grpc_health_probe_status gauge\n")
+	fmt.Fprintf(&buf, "grpc_health_probe_status{service=%q,addr=%q} %d\n", flService, flAddr, serving)
+	fmt.Fprintf(&buf, "# HELP grpc_health_probe_connect_duration_seconds Time taken to establish the connection for the last probe.\n")
+	fmt.Fprintf(&buf, "# TYPE grpc_health_probe_connect_duration_seconds gauge\n")
+	fmt.Fprintf(&buf, "grpc_health_probe_connect_duration_seconds{service=%q,addr=%q} %f\n", flService, flAddr, result.connDur.Seconds())
+	fmt.Fprintf(&buf, "# HELP grpc_health_probe_rpc_duration_seconds Time taken by the health check RPC of the last probe.\n")
+	fmt.Fprintf(&buf, "# TYPE grpc_health_probe_rpc_duration_seconds gauge\n")
+	fmt.Fprintf(&buf, "grpc_health_probe_rpc_duration_seconds{service=%q,addr=%q} %f\n", flService, flAddr, result.rpcDur.Seconds())
+	return buf.String()
+}
+
+// writeMetricsFile atomically (write-then-rename) writes result as a
+// Prometheus textfile at path, following the node_exporter textfile
+// collector convention of never leaving a scraper looking at a half-written
+// file.
+func writeMetricsFile(path string, result probeResult) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temp file for -metrics-file: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.WriteString(renderMetrics(result)); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write -metrics-file: %v", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write -metrics-file: %v", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to rename -metrics-file into place: %v", err)
+	}
+	return nil
+}
+
+// pushMetrics pushes result to a Prometheus Pushgateway at gatewayURL under
+// the given job and grouping labels, using the Pushgateway HTTP API directly
+// (PUT /metrics/job/<job>/<label>/<value>/...) rather than a
+// client_golang/push dependency, to keep the probe's own dependency graph
+// light.
+func pushMetrics(gatewayURL, job string, grouping pushgatewayLabels, result probeResult) error {
+	u, err := url.Parse(strings.TrimRight(gatewayURL, "/") + "/metrics/job/" + url.PathEscape(job))
+	if err != nil {
+		return fmt.Errorf("invalid -pushgateway-url: %v", err)
+	}
+	for k, v := range grouping {
+		u.Path += "/" + url.PathEscape(k) + "/" + url.PathEscape(v)
+	}
+
+	req, err := http.NewRequest(http.MethodPut, u.String(), strings.NewReader(renderMetrics(result)))
+	if err != nil {
+		return fmt.Errorf("failed to build pushgateway request: %v", err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to push metrics to pushgateway: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("pushgateway returned unexpected status %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,218 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// serviceList collects -services names from repeated and/or comma-separated
+// flag occurrences into a single ordered list.
+type serviceList []string
+
+func (l *serviceList) String() string { return strings.Join(*l, ",") }
+
+func (l *serviceList) Set(value string) error {
+	for _, s := range strings.Split(value, ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		*l = append(*l, s)
+	}
+	return nil
+}
+
+// serviceProbeResult is the outcome of checking a single service as part of
+// a runMultiProbe call.
+type serviceProbeResult struct {
+	Service string `json:"service"`
+	Status  string `json:"status"`
+	RPCMS   int64  `json:"rpc_ms"`
+	Err     string `json:"error,omitempty"`
+	Retcode int    `json:"-"`
+}
+
+// multiProbeResult is the outcome of a runMultiProbe call: the per-service
+// results plus the overall verdict obtained by combining them per -require.
+type multiProbeResult struct {
+	Services []serviceProbeResult
+	Overall  probeResult
+}
+
+// runMultiProbe dials flAddr once and issues a Health/Check for every entry
+// in services in parallel over the shared connection, combining the
+// per-service outcomes into an overall verdict per -require. Like runProbe,
+// it never calls log.Fatal/os.Exit so it can be called repeatedly from a
+// -watch loop as well as once from main.
+func runMultiProbe(ctx context.Context, opts []grpc.DialOption, services []string) multiProbeResult {
+	if flVerbose {
+		log.Print("establishing connection")
+	}
+	connStart := time.Now()
+	dialCtx, dialCancel := context.WithTimeout(ctx, flConnTimeout)
+	defer dialCancel()
+	if flOtelEndpoint != "" {
+		var dialSpan trace.Span
+		dialCtx, dialSpan = otelTracer.Start(dialCtx, "grpc_health_probe.Dial")
+		defer dialSpan.End()
+	}
+	conn, err := grpc.DialContext(dialCtx, flAddr, opts...)
+	if err != nil {
+		var authzErr *spiffeAuthorizationError
+		switch {
+		case errors.As(err, &authzErr):
+			return multiProbeResult{Overall: probeResult{Err: authzErr.Error(), Retcode: StatusSpiffeFailed}}
+		case err == context.DeadlineExceeded:
+			return multiProbeResult{Overall: probeResult{Err: fmt.Sprintf("timeout: failed to connect service %q within %v", flAddr, flConnTimeout), Retcode: StatusConnectionFailure}}
+		default:
+			return multiProbeResult{Overall: probeResult{Err: fmt.Sprintf("failed to connect service at %q: %+v", flAddr, err), Retcode: StatusConnectionFailure}}
+		}
+	}
+	connDuration := time.Since(connStart)
+	defer conn.Close()
+	if flVerbose {
+		log.Printf("connection established (took %v)", connDuration)
+	}
+
+	client := healthpb.NewHealthClient(conn)
+	results := make([]serviceProbeResult, len(services))
+	var wg sync.WaitGroup
+	for i, svc := range services {
+		wg.Add(1)
+		go func(i int, svc string) {
+			defer wg.Done()
+			results[i] = checkOneService(ctx, client, svc)
+		}(i, svc)
+	}
+	wg.Wait()
+
+	serving, rpcFailed := 0, false
+	for _, r := range results {
+		if r.Retcode == 0 {
+			serving++
+		}
+		if r.Retcode == StatusRPCFailure {
+			rpcFailed = true
+		}
+	}
+
+	var satisfied bool
+	switch flRequire {
+	case "any":
+		satisfied = serving >= 1
+	case "majority":
+		satisfied = serving*2 > len(results)
+	default: // "all"
+		satisfied = serving == len(results)
+	}
+
+	overall := probeResult{ConnMS: connDuration.Milliseconds(), connDur: connDuration}
+	if satisfied {
+		overall.Status = "SERVING"
+	} else {
+		overall.Err = fmt.Sprintf("require=%s not satisfied: %d/%d services serving", flRequire, serving, len(results))
+		if rpcFailed {
+			overall.Retcode = StatusRPCFailure
+		} else {
+			overall.Retcode = StatusUnhealthy
+		}
+	}
+
+	return multiProbeResult{Services: results, Overall: overall}
+}
+
+// checkOneService issues a single Health/Check for service over an
+// already-established connection and converts the RPC outcome into a
+// serviceProbeResult.
+func checkOneService(ctx context.Context, client healthpb.HealthClient, service string) serviceProbeResult {
+	rpcCtx, rpcCancel := context.WithTimeout(ctx, flRPCTimeout)
+	defer rpcCancel()
+	if flOtelEndpoint != "" {
+		var rpcSpan trace.Span
+		rpcCtx, rpcSpan = otelTracer.Start(rpcCtx, "grpc_health_probe.Check")
+		defer rpcSpan.End()
+	}
+	rpcCtx = metadata.NewOutgoingContext(rpcCtx, flRPCHeaders.MD)
+	outcome := doHealthCheck(rpcCtx, client, service, flRPCTimeout)
+
+	result := serviceProbeResult{Service: service, Err: outcome.Err, RPCMS: outcome.Duration.Milliseconds()}
+	if outcome.Err != "" {
+		result.Retcode = StatusRPCFailure
+		if !outcome.RPCFailed {
+			result.Retcode = StatusUnhealthy
+		}
+	} else {
+		result.Status = outcome.Status.String()
+	}
+	return result
+}
+
+// logMultiProbe reports a multiProbeResult as either a JSON line (-json,
+// extending the single-service schema with a "services" array) or classic
+// human-readable log lines, one per service plus the overall verdict.
+func logMultiProbe(result multiProbeResult) {
+	if flJSON {
+		type jsonResult struct {
+			TS       string               `json:"ts"`
+			Addr     string               `json:"addr"`
+			Require  string               `json:"require"`
+			Status   string               `json:"status"`
+			ConnMS   int64                `json:"conn_ms"`
+			Err      string               `json:"error,omitempty"`
+			Services []serviceProbeResult `json:"services"`
+		}
+		out, err := json.Marshal(jsonResult{
+			TS:       time.Now().UTC().Format(time.RFC3339Nano),
+			Addr:     flAddr,
+			Require:  flRequire,
+			Status:   result.Overall.Status,
+			ConnMS:   result.Overall.ConnMS,
+			Err:      result.Overall.Err,
+			Services: result.Services,
+		})
+		if err != nil {
+			log.Printf("error: failed to marshal -json probe result: %v", err)
+			return
+		}
+		fmt.Println(string(out))
+		return
+	}
+
+	for _, r := range result.Services {
+		if r.Err != "" {
+			log.Printf("error: service=%q %s", r.Service, r.Err)
+			continue
+		}
+		log.Printf("status: service=%q status=%v", r.Service, r.Status)
+	}
+	if result.Overall.Err != "" {
+		log.Printf("error: %s", result.Overall.Err)
+		return
+	}
+	log.Printf("status: require=%s satisfied", flRequire)
+}
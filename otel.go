@@ -0,0 +1,142 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetricgrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/metric"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.28.0"
+)
+
+// otelHeaders collects the values of repeated -otel-headers flags, each in
+// "name=value" form, sent as metadata on every OTLP export request (e.g. for
+// collector auth).
+type otelHeaders map[string]string
+
+func (h otelHeaders) String() string {
+	parts := make([]string, 0, len(h))
+	for k, v := range h {
+		parts = append(parts, fmt.Sprintf("%s=%s", k, v))
+	}
+	return strings.Join(parts, ",")
+}
+
+func (h otelHeaders) Set(value string) error {
+	parts := strings.SplitN(value, "=", 2)
+	if len(parts) != 2 {
+		return fmt.Errorf("invalid -otel-headers, expected 'name=value', got %q", value)
+	}
+	h[parts[0]] = parts[1]
+	return nil
+}
+
+var (
+	otelTracer = otel.Tracer("github.com/grpc-ecosystem/grpc-health-probe")
+	otelMeter  = otel.Meter("github.com/grpc-ecosystem/grpc-health-probe")
+
+	probeStatusGauge metric.Int64Gauge
+	rpcDurationHist  metric.Float64Histogram
+)
+
+func init() {
+	var err error
+	probeStatusGauge, err = otelMeter.Int64Gauge("probe.status",
+		metric.WithDescription("1 if the last probe reported SERVING, 0 otherwise"))
+	if err != nil {
+		log.Fatalf("failed to create probe.status instrument: %v", err)
+	}
+	rpcDurationHist, err = otelMeter.Float64Histogram("rpc.client.duration",
+		metric.WithDescription("duration of the Health/Check RPC"), metric.WithUnit("ms"))
+	if err != nil {
+		log.Fatalf("failed to create rpc.client.duration instrument: %v", err)
+	}
+}
+
+// initOTel dials -otel-endpoint and installs it as the global trace and
+// metric providers for the rest of the process. The returned func
+// force-flushes and shuts both providers down; callers must invoke it before
+// exiting, since a one-shot probe process otherwise exits long before a
+// batched exporter would next flush on its own.
+func initOTel(ctx context.Context) (func(context.Context) error, error) {
+	res, err := resource.New(ctx,
+		resource.WithAttributes(semconv.ServiceName(flOtelServiceName)),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build otel resource: %v", err)
+	}
+
+	traceOpts := []otlptracegrpc.Option{otlptracegrpc.WithEndpoint(flOtelEndpoint)}
+	metricOpts := []otlpmetricgrpc.Option{otlpmetricgrpc.WithEndpoint(flOtelEndpoint)}
+	if flOtelInsecure {
+		traceOpts = append(traceOpts, otlptracegrpc.WithInsecure())
+		metricOpts = append(metricOpts, otlpmetricgrpc.WithInsecure())
+	}
+	if len(flOtelHeaders) > 0 {
+		traceOpts = append(traceOpts, otlptracegrpc.WithHeaders(flOtelHeaders))
+		metricOpts = append(metricOpts, otlpmetricgrpc.WithHeaders(flOtelHeaders))
+	}
+
+	traceExp, err := otlptracegrpc.New(ctx, traceOpts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create otlp trace exporter: %v", err)
+	}
+	tp := sdktrace.NewTracerProvider(sdktrace.WithBatcher(traceExp), sdktrace.WithResource(res))
+	otel.SetTracerProvider(tp)
+
+	metricExp, err := otlpmetricgrpc.New(ctx, metricOpts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create otlp metric exporter: %v", err)
+	}
+	mp := sdkmetric.NewMeterProvider(
+		sdkmetric.WithReader(sdkmetric.NewPeriodicReader(metricExp)),
+		sdkmetric.WithResource(res),
+	)
+	otel.SetMeterProvider(mp)
+
+	return func(shutdownCtx context.Context) error {
+		return errors.Join(
+			tp.ForceFlush(shutdownCtx),
+			tp.Shutdown(shutdownCtx),
+			mp.ForceFlush(shutdownCtx),
+			mp.Shutdown(shutdownCtx),
+		)
+	}, nil
+}
+
+// recordOtelMetrics records the outcome of a single probe through the
+// probe.status and rpc.client.duration instruments.
+func recordOtelMetrics(result probeResult) {
+	attrs := metric.WithAttributes(
+		attribute.String("service", flService),
+		attribute.String("addr", flAddr),
+	)
+	status := int64(0)
+	if result.Retcode == 0 {
+		status = 1
+	}
+	probeStatusGauge.Record(context.Background(), status, attrs)
+	rpcDurationHist.Record(context.Background(), float64(result.rpcDur.Milliseconds()), attrs)
+}
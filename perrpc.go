@@ -0,0 +1,103 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/oauth2"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/oauth"
+)
+
+// buildPerRPCCredentials returns the per-RPC credentials requested by the
+// -oauth-token*/-google-adc flags, or nil if none were given. At most one
+// may be set (enforced by init's validation).
+func buildPerRPCCredentials(ctx context.Context) (credentials.PerRPCCredentials, error) {
+	switch {
+	case flOAuthToken != "":
+		return oauth.NewOauthAccess(&oauth2.Token{AccessToken: flOAuthToken, TokenType: "Bearer"}), nil
+	case flOAuthTokenFile != "":
+		return oauth.TokenSource{TokenSource: &fileTokenSource{path: flOAuthTokenFile}}, nil
+	case flOAuthTokenExec != "":
+		return oauth.TokenSource{TokenSource: &execTokenSource{cmd: flOAuthTokenExec}}, nil
+	case flGoogleADC:
+		return oauth.NewApplicationDefault(ctx)
+	default:
+		return nil, nil
+	}
+}
+
+// fileTokenSource implements oauth2.TokenSource by re-reading a bearer token
+// from disk whenever the file's mtime changes, so a rotated token picked up
+// by a sidecar (e.g. a projected Kubernetes secret) is used on the probe's
+// next RPC without a restart.
+type fileTokenSource struct {
+	path string
+
+	mu    sync.Mutex
+	mod   time.Time
+	token *oauth2.Token
+}
+
+func (s *fileTokenSource) Token() (*oauth2.Token, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	info, err := os.Stat(s.path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat -oauth-token-file %q: %v", s.path, err)
+	}
+	if s.token != nil && !info.ModTime().After(s.mod) {
+		return s.token, nil
+	}
+	raw, err := os.ReadFile(s.path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read -oauth-token-file %q: %v", s.path, err)
+	}
+	s.token = &oauth2.Token{AccessToken: strings.TrimSpace(string(raw)), TokenType: "Bearer"}
+	s.mod = info.ModTime()
+	return s.token, nil
+}
+
+// execTokenSource implements oauth2.TokenSource by running cmd and using its
+// trimmed stdout as the bearer token, re-run whenever the cached token is
+// expired (or was never obtained).
+type execTokenSource struct {
+	cmd string
+
+	mu    sync.Mutex
+	token *oauth2.Token
+}
+
+func (s *execTokenSource) Token() (*oauth2.Token, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.token.Valid() {
+		return s.token, nil
+	}
+	out, err := exec.Command("sh", "-c", s.cmd).Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to run -oauth-token-exec command %q: %v", s.cmd, err)
+	}
+	s.token = &oauth2.Token{AccessToken: strings.TrimSpace(string(out)), TokenType: "Bearer"}
+	return s.token, nil
+}
@@ -10,11 +10,19 @@ import (
 	"log"
 	"time"
 
+	"github.com/spiffe/go-spiffe/v2/spiffeid"
+	"github.com/spiffe/go-spiffe/v2/spiffetls/tlsconfig"
+	"github.com/spiffe/go-spiffe/v2/workloadapi"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/alts"
+	"google.golang.org/grpc/credentials/insecure"
+	xdscreds "google.golang.org/grpc/credentials/xds"
 	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/peer"
 	"google.golang.org/grpc/status"
+	_ "google.golang.org/grpc/xds" // register the xds:/// resolver
 )
 
 const (
@@ -26,21 +34,40 @@ const (
 	StatusRPCFailure = 3
 	// StatusUnhealthy indicates rpc succeeded but indicates unhealthy service.
 	StatusUnhealthy = 4
+	// StatusSpiffeFailed indicates failure to retrieve credentials using the SPIFFE workload API.
+	StatusSpiffeFailed = 20
 )
 
 type Config struct {
-	Addr          string
-	Service       string
-	UserAgent     string
-	ConnTimeout   time.Duration
-	RPCTimeout    time.Duration
-	TLS           bool
-	TLSNoVerify   bool
-	TLSCACert     string
-	TLSClientCert string
-	TLSClientKey  string
-	TLSServerName string
-	Verbose       bool
+	Addr             string
+	Service          string
+	UserAgent        string
+	ConnTimeout      time.Duration
+	RPCTimeout       time.Duration
+	TLS              bool
+	TLSNoVerify      bool
+	TLSCACert        string
+	TLSClientCert    string
+	TLSClientKey     string
+	TLSServerName    string
+	Spiffe           bool
+	SpiffeSocket     string
+	SpiffeExpectedID string
+	ALTS             bool
+	XDSCreds         bool
+	Verbose          bool
+}
+
+// credentialSourceCount returns how many mutually exclusive credential
+// sources are enabled in c.
+func (c *Config) credentialSourceCount() int {
+	n := 0
+	for _, enabled := range []bool{c.TLS, c.ALTS, c.XDSCreds, c.Spiffe} {
+		if enabled {
+			n++
+		}
+	}
+	return n
 }
 
 func (c *Config) Validate() error {
@@ -77,6 +104,15 @@ func (c *Config) Validate() error {
 	if c.TLSNoVerify && c.TLSServerName != "" {
 		return errors.New("cannot specify -tls-server-name with -tls-no-verify (server name would not be used)")
 	}
+	if !c.Spiffe && c.SpiffeSocket != "" {
+		return errors.New("specified -spiffe-socket without specifying -spiffe")
+	}
+	if !c.Spiffe && c.SpiffeExpectedID != "" {
+		return errors.New("specified -spiffe-expected-id without specifying -spiffe")
+	}
+	if c.credentialSourceCount() > 1 {
+		return errors.New("-tls, -alts, -xds-creds and -spiffe are mutually exclusive credential sources")
+	}
 
 	return nil
 }
@@ -90,7 +126,19 @@ func (e Error) Error() string {
 	return e.Err
 }
 
-func Check(ctx context.Context, config *Config) (*healthpb.HealthCheckResponse, *Error) {
+// Result is the outcome of a Check, broken down by phase so callers (e.g.
+// metrics exporters) can report connect and RPC timing separately. It is
+// filled in as far as Check got even when it ultimately returns an Error,
+// so a caller can still report e.g. connect timing for an RPC failure, or
+// the serving status and peer certificates for an unhealthy response.
+type Result struct {
+	Response         *healthpb.HealthCheckResponse
+	ConnectDuration  time.Duration
+	RPCDuration      time.Duration
+	PeerCertificates []*x509.Certificate
+}
+
+func Check(ctx context.Context, config *Config) (*Result, *Error) {
 	if err := config.Validate(); err != nil {
 		return nil, &Error{err.Error(), StatusInvalidArguments}
 	}
@@ -104,6 +152,20 @@ func Check(ctx context.Context, config *Config) (*healthpb.HealthCheckResponse,
 			return nil, &Error{fmt.Sprintf("failed to initialize tls credentials. error=%v", err), StatusInvalidArguments}
 		}
 		opts = append(opts, grpc.WithTransportCredentials(creds))
+	} else if config.Spiffe {
+		creds, err := buildSpiffeCredentials(ctx, config.SpiffeSocket, config.SpiffeExpectedID)
+		if err != nil {
+			return nil, &Error{fmt.Sprintf("failed to initialize spiffe credentials. error=%v", err), StatusSpiffeFailed}
+		}
+		opts = append(opts, grpc.WithTransportCredentials(creds))
+	} else if config.ALTS {
+		opts = append(opts, grpc.WithTransportCredentials(alts.NewClientCreds(alts.DefaultClientOptions())))
+	} else if config.XDSCreds {
+		creds, err := xdscreds.NewClientCredentials(xdscreds.ClientOptions{FallbackCreds: insecure.NewCredentials()})
+		if err != nil {
+			return nil, &Error{fmt.Sprintf("failed to initialize xds credentials. error=%v", err), StatusInvalidArguments}
+		}
+		opts = append(opts, grpc.WithTransportCredentials(creds))
 	} else {
 		opts = append(opts, grpc.WithInsecure())
 	}
@@ -122,35 +184,40 @@ func Check(ctx context.Context, config *Config) (*healthpb.HealthCheckResponse,
 			return nil, &Error{fmt.Sprintf("error: failed to connect service at %q: %+v", config.Addr, err), StatusConnectionFailure}
 		}
 	}
-	connDuration := time.Since(connStart)
+	result := &Result{ConnectDuration: time.Since(connStart)}
 	defer conn.Close()
 	if config.Verbose {
-		log.Printf("connection establisted (took %v)", connDuration)
+		log.Printf("connection establisted (took %v)", result.ConnectDuration)
 	}
 
 	rpcStart := time.Now()
 	rpcCtx, rpcCancel := context.WithTimeout(ctx, config.RPCTimeout)
 	defer rpcCancel()
-	resp, err := healthpb.NewHealthClient(conn).Check(rpcCtx, &healthpb.HealthCheckRequest{Service: config.Service})
+	var peerInfo peer.Peer
+	resp, err := healthpb.NewHealthClient(conn).Check(rpcCtx, &healthpb.HealthCheckRequest{Service: config.Service}, grpc.Peer(&peerInfo))
+	result.RPCDuration = time.Since(rpcStart)
+	if tlsInfo, ok := peerInfo.AuthInfo.(credentials.TLSInfo); ok {
+		result.PeerCertificates = tlsInfo.State.PeerCertificates
+	}
 	if err != nil {
 		if stat, ok := status.FromError(err); ok && stat.Code() == codes.Unimplemented {
-			return nil, &Error{fmt.Sprintf("error: this server does not implement the grpc health protocol (grpc.health.v1.Health)"), StatusRPCFailure}
+			return result, &Error{fmt.Sprintf("error: this server does not implement the grpc health protocol (grpc.health.v1.Health)"), StatusRPCFailure}
 		} else if stat, ok := status.FromError(err); ok && stat.Code() == codes.DeadlineExceeded {
-			return nil, &Error{fmt.Sprintf("timeout: health rpc did not complete within %v", config.RPCTimeout), StatusRPCFailure}
+			return result, &Error{fmt.Sprintf("timeout: health rpc did not complete within %v", config.RPCTimeout), StatusRPCFailure}
 		} else {
-			return nil, &Error{fmt.Sprintf("error: health rpc failed: %+v", err), StatusRPCFailure}
+			return result, &Error{fmt.Sprintf("error: health rpc failed: %+v", err), StatusRPCFailure}
 		}
 	}
-	rpcDuration := time.Since(rpcStart)
+	result.Response = resp
 
 	if resp.GetStatus() != healthpb.HealthCheckResponse_SERVING {
-		return nil, &Error{fmt.Sprintf("service unhealthy (responded with %q)", resp.GetStatus().String()), StatusUnhealthy}
+		return result, &Error{fmt.Sprintf("service unhealthy (responded with %q)", resp.GetStatus().String()), StatusUnhealthy}
 	}
 	if config.Verbose {
-		log.Printf("time elapsed: connect=%v rpc=%v", connDuration, rpcDuration)
+		log.Printf("time elapsed: connect=%v rpc=%v", result.ConnectDuration, result.RPCDuration)
 	}
 
-	return resp, nil
+	return result, nil
 }
 
 func buildCredentials(skipVerify bool, caCerts, clientCert, clientKey, serverName string) (credentials.TransportCredentials, error) {
@@ -183,3 +250,29 @@ func buildCredentials(skipVerify bool, caCerts, clientCert, clientKey, serverNam
 	}
 	return credentials.NewTLS(&cfg), nil
 }
+
+// buildSpiffeCredentials fetches an X.509-SVID from the SPIRE Workload API
+// and returns mTLS transport credentials authorized against expectedID (or
+// any peer identity when expectedID is empty). socketPath overrides the
+// default workload API address when non-empty.
+func buildSpiffeCredentials(ctx context.Context, socketPath, expectedID string) (credentials.TransportCredentials, error) {
+	var opts []workloadapi.X509SourceOption
+	if socketPath != "" {
+		opts = append(opts, workloadapi.WithClientOptions(workloadapi.WithAddr(socketPath)))
+	}
+	source, err := workloadapi.NewX509Source(ctx, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch x509 SVID from the SPIFFE workload API: %v", err)
+	}
+
+	authorizer := tlsconfig.AuthorizeAny()
+	if expectedID != "" {
+		id, err := spiffeid.FromString(expectedID)
+		if err != nil {
+			return nil, fmt.Errorf("invalid -spiffe-expected-id %q: %v", expectedID, err)
+		}
+		authorizer = tlsconfig.AuthorizeID(id)
+	}
+
+	return credentials.NewTLS(tlsconfig.MTLSClientConfig(source, source, authorizer)), nil
+}
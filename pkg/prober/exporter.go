@@ -0,0 +1,102 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prober
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Exporter turns a checker into a long-running Prometheus/OpenMetrics scrape
+// target: it runs Check on a fixed interval in the background and serves the
+// most recent result as text-format metrics, so grpc-health-probe can double
+// as a blackbox exporter instead of a one-shot process.
+type Exporter struct {
+	checker  *checker
+	interval time.Duration
+
+	mu          sync.Mutex
+	lastStatus  string
+	lastSuccess bool
+	lastRPCSecs float64
+	lastAt      time.Time
+}
+
+// NewExporter wraps checker so its results are periodically collected and
+// served as metrics.
+func NewExporter(checker *checker, interval time.Duration) *Exporter {
+	return &Exporter{checker: checker, interval: interval}
+}
+
+// Run probes on the configured interval until ctx is done.
+func (e *Exporter) Run(ctx context.Context) {
+	e.probeOnce(ctx)
+	ticker := time.NewTicker(e.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			e.probeOnce(ctx)
+		}
+	}
+}
+
+func (e *Exporter) probeOnce(ctx context.Context) {
+	start := time.Now()
+	resp, err := e.checker.Check(ctx)
+	elapsed := time.Since(start)
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.lastRPCSecs = elapsed.Seconds()
+	e.lastAt = time.Now()
+	if err != nil {
+		e.lastSuccess = false
+		e.lastStatus = "UNKNOWN"
+		return
+	}
+	e.lastSuccess = true
+	e.lastStatus = resp.GetStatus().String()
+}
+
+// ServeHTTP renders the most recent probe result as Prometheus text format.
+func (e *Exporter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	e.mu.Lock()
+	success, status, rpcSecs, at := e.lastSuccess, e.lastStatus, e.lastRPCSecs, e.lastAt
+	e.mu.Unlock()
+
+	successVal := 0
+	if success {
+		successVal = 1
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	fmt.Fprintf(w, "# HELP grpc_health_probe_status Whether the last probe reported SERVING (1) or not (0).\n")
+	fmt.Fprintf(w, "# TYPE grpc_health_probe_status gauge\n")
+	fmt.Fprintf(w, "grpc_health_probe_status{service=%q,last_status=%q} %d\n", e.checker.config.Service, status, successVal)
+	fmt.Fprintf(w, "# HELP grpc_health_probe_rpc_duration_seconds Duration of the last health check RPC.\n")
+	fmt.Fprintf(w, "# TYPE grpc_health_probe_rpc_duration_seconds gauge\n")
+	fmt.Fprintf(w, "grpc_health_probe_rpc_duration_seconds{service=%q} %f\n", e.checker.config.Service, rpcSecs)
+	if success {
+		fmt.Fprintf(w, "# HELP grpc_health_probe_last_success_timestamp_seconds Unix time of the last successful probe.\n")
+		fmt.Fprintf(w, "# TYPE grpc_health_probe_last_success_timestamp_seconds gauge\n")
+		fmt.Fprintf(w, "grpc_health_probe_last_success_timestamp_seconds{service=%q} %d\n", e.checker.config.Service, at.Unix())
+	}
+}
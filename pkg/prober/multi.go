@@ -0,0 +1,171 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prober
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"sync"
+
+	"google.golang.org/grpc/resolver"
+	"google.golang.org/grpc/serviceconfig"
+
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// EndpointResult is the outcome of checking a single resolved backend when
+// probing a multi-endpoint target (e.g. dns:/// or xds:///) with MultiCheck.
+type EndpointResult struct {
+	Addr   string
+	Status healthpb.HealthCheckResponse_ServingStatus
+	Err    *Error
+}
+
+// servingCount reports how many results are SERVING.
+func servingCount(results []EndpointResult) int {
+	n := 0
+	for _, r := range results {
+		if r.Err == nil && r.Status == healthpb.HealthCheckResponse_SERVING {
+			n++
+		}
+	}
+	return n
+}
+
+// EvaluateQuorum applies quorum (one of "all", "any", or "majority"; "all"
+// if empty) to results and returns nil if it is satisfied, or an Error
+// describing the shortfall otherwise.
+func EvaluateQuorum(results []EndpointResult, quorum string) *Error {
+	serving := servingCount(results)
+	total := len(results)
+	satisfied := false
+	switch quorum {
+	case "", "all":
+		satisfied = serving == total
+	case "any":
+		satisfied = serving > 0
+	case "majority":
+		satisfied = serving*2 > total
+	default:
+		return &Error{fmt.Sprintf("unknown quorum %q (want all, any or majority)", quorum), StatusInvalidArguments}
+	}
+	if !satisfied {
+		return &Error{fmt.Sprintf("only %d/%d endpoints serving, quorum %q not met", serving, total, quorum), StatusUnhealthy}
+	}
+	return nil
+}
+
+// MultiCheck resolves c.config.Addr with gRPC's normal resolver machinery
+// (dns:///, xds:///, or a plain host:port handled by the passthrough
+// resolver), then issues an individual Check against every resolved backend
+// instead of letting a single ClientConn pick one for us. This catches a
+// single misbehaving pod behind a Kubernetes headless Service or an xDS
+// control plane that a load-balanced probe would otherwise mask.
+func (c *checker) MultiCheck(ctx context.Context) ([]EndpointResult, *Error) {
+	addrs, err := resolveEndpoints(ctx, c.config.Addr)
+	if err != nil {
+		return nil, &Error{fmt.Sprintf("error: failed to resolve %q: %+v", c.config.Addr, err), StatusConnectionFailure}
+	}
+
+	results := make([]EndpointResult, len(addrs))
+	var wg sync.WaitGroup
+	for i, addr := range addrs {
+		wg.Add(1)
+		go func(i int, addr string) {
+			defer wg.Done()
+			sub := *c.config
+			sub.Addr = addr
+			subChecker := &checker{&sub, c.logger}
+			resp, cerr := subChecker.Check(ctx)
+			res := EndpointResult{Addr: addr, Err: cerr}
+			if resp != nil {
+				res.Status = resp.GetStatus()
+			}
+			results[i] = res
+		}(i, addr)
+	}
+	wg.Wait()
+	return results, nil
+}
+
+// resolveEndpoints expands target into the individual "host:port" addresses
+// gRPC's registered resolver for its scheme would hand to a balancer. A
+// target with no scheme or an unregistered scheme resolves to itself.
+func resolveEndpoints(ctx context.Context, target string) ([]string, error) {
+	u, err := url.Parse(target)
+	scheme := ""
+	if err == nil {
+		scheme = u.Scheme
+	}
+	builder := resolver.Get(scheme)
+	if builder == nil {
+		return []string{target}, nil
+	}
+
+	cc := &capturingClientConn{updates: make(chan []resolver.Address, 1), errs: make(chan error, 1)}
+	r, err := builder.Build(resolver.Target{URL: *u}, cc, resolver.BuildOptions{})
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	select {
+	case addrs := <-cc.updates:
+		out := make([]string, len(addrs))
+		for i, a := range addrs {
+			out[i] = a.Addr
+		}
+		return out, nil
+	case err := <-cc.errs:
+		return nil, err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// capturingClientConn implements resolver.ClientConn just enough to receive
+// the first resolution result synchronously, instead of the async updates a
+// real gRPC ClientConn would keep handling for the life of a connection.
+type capturingClientConn struct {
+	updates chan []resolver.Address
+	errs    chan error
+}
+
+func (c *capturingClientConn) UpdateState(s resolver.State) error {
+	select {
+	case c.updates <- s.Addresses:
+	default:
+	}
+	return nil
+}
+
+func (c *capturingClientConn) ReportError(err error) {
+	select {
+	case c.errs <- err:
+	default:
+	}
+}
+
+func (c *capturingClientConn) NewAddress(addrs []resolver.Address) {
+	select {
+	case c.updates <- addrs:
+	default:
+	}
+}
+
+func (c *capturingClientConn) ParseServiceConfig(string) *serviceconfig.ParseResult {
+	return nil
+}
@@ -0,0 +1,78 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prober
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/clientcredentials"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/oauth"
+)
+
+// insecurePerRPCCredentials wraps a credentials.PerRPCCredentials to report
+// RequireTransportSecurity() == false. oauth.NewOauthAccess and
+// oauth.TokenSource always report true, which makes grpc refuse to send the
+// per-RPC credentials over a non-TLS transport regardless of
+// -allow-insecure-credentials; this wrapper is what actually lets that flag
+// take effect.
+type insecurePerRPCCredentials struct {
+	credentials.PerRPCCredentials
+}
+
+func (insecurePerRPCCredentials) RequireTransportSecurity() bool { return false }
+
+// buildPerRPCCredentials returns the per-RPC credentials requested by the
+// config, or nil if none were. At most one of BearerToken, BearerTokenFile
+// and OAuth2TokenURL may be set (enforced by Config.Validate). If
+// AllowInsecureCredentials is set, the result is wrapped so grpc will send it
+// over a non-TLS transport instead of refusing the RPC.
+func (c *Config) buildPerRPCCredentials() (credentials.PerRPCCredentials, error) {
+	creds, err := c.buildOauthCredentials()
+	if err != nil || creds == nil {
+		return creds, err
+	}
+	if c.AllowInsecureCredentials {
+		creds = insecurePerRPCCredentials{creds}
+	}
+	return creds, nil
+}
+
+func (c *Config) buildOauthCredentials() (credentials.PerRPCCredentials, error) {
+	switch {
+	case c.BearerToken != "":
+		return oauth.NewOauthAccess(&oauth2.Token{AccessToken: c.BearerToken, TokenType: "Bearer"}), nil
+	case c.BearerTokenFile != "":
+		token, err := os.ReadFile(c.BearerTokenFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read -bearer-token-file %q: %v", c.BearerTokenFile, err)
+		}
+		return oauth.NewOauthAccess(&oauth2.Token{AccessToken: strings.TrimSpace(string(token)), TokenType: "Bearer"}), nil
+	case c.OAuth2TokenURL != "":
+		cc := clientcredentials.Config{
+			ClientID:     c.OAuth2ClientID,
+			ClientSecret: c.OAuth2ClientSecret,
+			TokenURL:     c.OAuth2TokenURL,
+			Scopes:       c.OAuth2Scopes,
+		}
+		return oauth.TokenSource{TokenSource: cc.TokenSource(context.Background())}, nil
+	default:
+		return nil, nil
+	}
+}
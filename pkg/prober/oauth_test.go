@@ -0,0 +1,39 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prober
+
+import "testing"
+
+func TestBuildPerRPCCredentials_requiresTransportSecurityByDefault(t *testing.T) {
+	c := &Config{BearerToken: "t"}
+	creds, err := c.buildPerRPCCredentials()
+	if err != nil {
+		t.Fatalf("buildPerRPCCredentials failed: %v", err)
+	}
+	if !creds.RequireTransportSecurity() {
+		t.Fatal("expected per-RPC credentials to require transport security without -allow-insecure-credentials")
+	}
+}
+
+func TestBuildPerRPCCredentials_allowInsecureCredentials(t *testing.T) {
+	c := &Config{BearerToken: "t", AllowInsecureCredentials: true}
+	creds, err := c.buildPerRPCCredentials()
+	if err != nil {
+		t.Fatalf("buildPerRPCCredentials failed: %v", err)
+	}
+	if creds.RequireTransportSecurity() {
+		t.Fatal("expected -allow-insecure-credentials to make grpc willing to send credentials over plaintext")
+	}
+}
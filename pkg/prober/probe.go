@@ -4,7 +4,7 @@
 // you may not use this file except in compliance with the License.
 // You may obtain a copy of the License at
 //
-//      http://www.apache.org/licenses/LICENSE-2.0
+//	http://www.apache.org/licenses/LICENSE-2.0
 //
 // Unless required by applicable law or agreed to in writing, software
 // distributed under the License is distributed on an "AS IS" BASIS,
@@ -32,13 +32,13 @@ import (
 
 const (
 	// StatusInvalidArguments indicates specified invalid arguments.
-	StatusInvalidArguments = iota
+	StatusInvalidArguments = 1
 	// StatusConnectionFailure indicates connection failed.
-	StatusConnectionFailure
+	StatusConnectionFailure = 2
 	// StatusRPCFailure indicates rpc failed.
-	StatusRPCFailure
+	StatusRPCFailure = 3
 	// StatusUnhealthy indicates rpc succeeded but indicates unhealthy service.
-	StatusUnhealthy
+	StatusUnhealthy = 4
 )
 
 type Config struct {
@@ -53,7 +53,39 @@ type Config struct {
 	TLSClientCert string
 	TLSClientKey  string
 	TLSServerName string
-	Verbose       bool
+	// TLSSpiffeID, when set (with TLS), requires the server's certificate to
+	// carry this exact spiffe:// URI SAN, verifying workload identity
+	// instead of (or in addition to) a DNS hostname.
+	TLSSpiffeID string
+	// TLSSpiffeTrustDomain, when set (with TLS) and TLSSpiffeID is not,
+	// requires the server's spiffe:// URI SAN to belong to this trust
+	// domain, without pinning a specific workload path.
+	TLSSpiffeTrustDomain string
+	Verbose              bool
+	// Watch, when true, tells callers to use checker.Watch instead of
+	// checker.Check, subscribing to server-pushed status transitions for
+	// WatchDuration instead of polling.
+	Watch         bool
+	WatchDuration time.Duration
+	// TLSReload, when true (with TLS), reloads the client cert/key pair and
+	// CA bundle from disk whenever their mtime changes, so long-running
+	// probe modes pick up rotated certificates without restarting.
+	TLSReload bool
+	// BearerToken attaches a static "Authorization: Bearer <token>" header to
+	// every RPC.
+	BearerToken string
+	// BearerTokenFile is like BearerToken but reads the token from a file.
+	BearerTokenFile string
+	// OAuth2TokenURL, when set, fetches per-RPC bearer tokens via the OAuth2
+	// client-credentials flow, refreshing them as they expire.
+	OAuth2TokenURL     string
+	OAuth2ClientID     string
+	OAuth2ClientSecret string
+	OAuth2Scopes       []string
+	// AllowInsecureCredentials permits per-RPC credentials (BearerToken,
+	// BearerTokenFile, OAuth2TokenURL) to be sent over a non-TLS transport,
+	// which otherwise is rejected to avoid leaking tokens in plaintext.
+	AllowInsecureCredentials bool
 }
 
 func (c *Config) Validate() error {
@@ -90,6 +122,25 @@ func (c *Config) Validate() error {
 	if c.TLSNoVerify && c.TLSServerName != "" {
 		return errors.New("cannot specify -tls-server-name with -tls-no-verify (server name would not be used)")
 	}
+	if !c.TLS && (c.TLSSpiffeID != "" || c.TLSSpiffeTrustDomain != "") {
+		return errors.New("specified -tls-spiffe-id or -tls-spiffe-trust-domain without specifying -tls")
+	}
+	if c.TLSNoVerify && (c.TLSSpiffeID != "" || c.TLSSpiffeTrustDomain != "") {
+		return errors.New("cannot specify -tls-spiffe-id/-tls-spiffe-trust-domain with -tls-no-verify (peer identity would not be checked)")
+	}
+
+	perRPCCredSources := 0
+	for _, set := range []bool{c.BearerToken != "", c.BearerTokenFile != "", c.OAuth2TokenURL != ""} {
+		if set {
+			perRPCCredSources++
+		}
+	}
+	if perRPCCredSources > 1 {
+		return errors.New("-bearer-token, -bearer-token-file and -oauth2-token-url are mutually exclusive")
+	}
+	if perRPCCredSources > 0 && !c.TLS && !c.AllowInsecureCredentials {
+		return errors.New("per-RPC credentials require -tls unless -allow-insecure-credentials is set")
+	}
 
 	return nil
 }
@@ -124,7 +175,7 @@ func (c *checker) Check(ctx context.Context) (*healthpb.HealthCheckResponse, *Er
 		grpc.WithUserAgent(c.config.UserAgent),
 		grpc.WithBlock()}
 	if c.config.TLS {
-		creds, err := buildCredentials(c.config.TLSNoVerify, c.config.TLSCACert, c.config.TLSClientCert, c.config.TLSClientKey, c.config.TLSServerName)
+		creds, err := c.buildTLSCredentials()
 		if err != nil {
 			return nil, &Error{fmt.Sprintf("failed to initialize tls credentials. error=%v", err), StatusInvalidArguments}
 		}
@@ -132,6 +183,13 @@ func (c *checker) Check(ctx context.Context) (*healthpb.HealthCheckResponse, *Er
 	} else {
 		opts = append(opts, grpc.WithInsecure())
 	}
+	perRPCCreds, err := c.config.buildPerRPCCredentials()
+	if err != nil {
+		return nil, &Error{fmt.Sprintf("failed to initialize per-rpc credentials. error=%v", err), StatusInvalidArguments}
+	}
+	if perRPCCreds != nil {
+		opts = append(opts, grpc.WithPerRPCCredentials(perRPCCreds))
+	}
 
 	if c.config.Verbose {
 		log.Print("establishing connection")
@@ -178,13 +236,25 @@ func (c *checker) Check(ctx context.Context) (*healthpb.HealthCheckResponse, *Er
 	return resp, nil
 }
 
+// buildTLSCredentials returns reloadable credentials when the config opts
+// into TLSReload, and static ones loaded once otherwise. Either way, when
+// TLSSpiffeID or TLSSpiffeTrustDomain is set, the resulting credentials also
+// verify the server's spiffe:// URI SAN alongside the normal certificate
+// chain validation.
+func (c *checker) buildTLSCredentials() (credentials.TransportCredentials, error) {
+	if c.config.TLSReload {
+		return newReloadableCredentials(c.config.TLSClientCert, c.config.TLSClientKey, c.config.TLSCACert, c.config.TLSServerName, c.config.TLSNoVerify, c.config.TLSSpiffeID, c.config.TLSSpiffeTrustDomain, c.config.Addr), nil
+	}
+	return buildCredentials(c.config.TLSNoVerify, c.config.TLSCACert, c.config.TLSClientCert, c.config.TLSClientKey, c.config.TLSServerName, c.config.TLSSpiffeID, c.config.TLSSpiffeTrustDomain)
+}
+
 func (c *checker) log(format string, v ...interface{}) {
 	if c.logger != nil {
 		c.logger.Printf(format, v...)
 	}
 }
 
-func buildCredentials(skipVerify bool, caCerts, clientCert, clientKey, serverName string) (credentials.TransportCredentials, error) {
+func buildCredentials(skipVerify bool, caCerts, clientCert, clientKey, serverName, spiffeID, spiffeTrustDomain string) (credentials.TransportCredentials, error) {
 	var cfg tls.Config
 
 	if clientCert != "" && clientKey != "" {
@@ -212,5 +282,8 @@ func buildCredentials(skipVerify bool, caCerts, clientCert, clientKey, serverNam
 	if serverName != "" {
 		cfg.ServerName = serverName
 	}
+	if spiffeID != "" || spiffeTrustDomain != "" {
+		cfg.VerifyPeerCertificate = spiffeVerifier(spiffeID, spiffeTrustDomain)
+	}
 	return credentials.NewTLS(&cfg), nil
 }
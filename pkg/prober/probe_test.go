@@ -0,0 +1,72 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prober
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestCheck_withTLSCredentials(t *testing.T) {
+	addr := startTLSServer(t, testdataPath("127.0.0.1.pem"), testdataPath("127.0.0.1-key.pem"))
+
+	c, cerr := NewChecker(&Config{
+		Addr:        addr,
+		ConnTimeout: time.Second,
+		RPCTimeout:  time.Second,
+		TLS:         true,
+		TLSCACert:   testdataPath("ca.pem"),
+	}, nil)
+	if cerr != nil {
+		t.Fatalf("NewChecker failed: %v", cerr)
+	}
+
+	if _, cerr := c.Check(context.Background()); cerr != nil {
+		t.Fatalf("Check failed: %v", cerr)
+	}
+}
+
+func TestCheck_withTLSCredentials_hostnameMismatch(t *testing.T) {
+	// The server's cert is for example.com, but it's dialed over 127.0.0.1,
+	// and no -tls-server-name is given: buildCredentials must not silently
+	// skip the hostname check.
+	addr := startTLSServer(t, testdataPath("example.com.pem"), testdataPath("example.com-key.pem"))
+
+	c, cerr := NewChecker(&Config{
+		Addr:        addr,
+		ConnTimeout: time.Second,
+		RPCTimeout:  time.Second,
+		TLS:         true,
+		TLSCACert:   testdataPath("ca.pem"),
+	}, nil)
+	if cerr != nil {
+		t.Fatalf("NewChecker failed: %v", cerr)
+	}
+
+	if _, cerr := c.Check(context.Background()); cerr == nil {
+		t.Fatal("Check succeeded despite a certificate/hostname mismatch")
+	}
+}
+
+func TestNewChecker_invalidConfigExitsNonZero(t *testing.T) {
+	_, cerr := NewChecker(&Config{}, nil)
+	if cerr == nil {
+		t.Fatal("NewChecker succeeded despite a missing -addr")
+	}
+	if cerr.ExitCode == 0 {
+		t.Fatalf("got ExitCode 0 for an invalid config, want StatusInvalidArguments (%d)", StatusInvalidArguments)
+	}
+}
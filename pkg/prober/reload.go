@@ -0,0 +1,159 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prober
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/credentials"
+)
+
+// reloadableCredentials watches the client certificate, key, and CA bundle
+// files on disk (via mtime) and reloads them as they change, so a
+// long-running probe (e.g. Watch) picks up certificates rotated in-place by
+// cert-manager or Vault without restarting. Verification is performed
+// manually in VerifyPeerCertificate since tls.Config has no equivalent
+// reload hook for the client-side root pool.
+type reloadableCredentials struct {
+	certFile, keyFile, caFile, serverName string
+	skipVerify                            bool
+	spiffeID, spiffeTrustDomain           string
+
+	mu      sync.Mutex
+	certMod time.Time
+	caMod   time.Time
+	cert    *tls.Certificate
+	caPool  *x509.CertPool
+}
+
+// newReloadableCredentials returns reloadable TLS credentials for addr. If
+// serverName is empty, the host half of addr is used instead - the same
+// default grpc applies for the non-reloading path - since
+// verifyPeerCertificate does its own manual chain verification and would
+// otherwise never check the peer's hostname at all.
+func newReloadableCredentials(certFile, keyFile, caFile, serverName string, skipVerify bool, spiffeID, spiffeTrustDomain, addr string) credentials.TransportCredentials {
+	if serverName == "" {
+		serverName = hostFromAddr(addr)
+	}
+	r := &reloadableCredentials{certFile: certFile, keyFile: keyFile, caFile: caFile, serverName: serverName, skipVerify: skipVerify, spiffeID: spiffeID, spiffeTrustDomain: spiffeTrustDomain}
+	cfg := &tls.Config{
+		ServerName: serverName,
+		// Verification happens in VerifyPeerCertificate below, against a
+		// root pool reloaded from caFile on every handshake.
+		InsecureSkipVerify:    true,
+		GetClientCertificate:  r.getClientCertificate,
+		VerifyPeerCertificate: r.verifyPeerCertificate,
+	}
+	return credentials.NewTLS(cfg)
+}
+
+func (r *reloadableCredentials) getClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	if r.certFile == "" || r.keyFile == "" {
+		return &tls.Certificate{}, nil
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	fi, err := os.Stat(r.certFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat client cert %q: %v", r.certFile, err)
+	}
+	if r.cert == nil || fi.ModTime().After(r.certMod) {
+		cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to reload client cert/key pair: %v", err)
+		}
+		r.cert = &cert
+		r.certMod = fi.ModTime()
+	}
+	return r.cert, nil
+}
+
+func (r *reloadableCredentials) caPoolLocked() (*x509.CertPool, error) {
+	if r.caFile == "" {
+		return nil, nil
+	}
+	fi, err := os.Stat(r.caFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat CA bundle %q: %v", r.caFile, err)
+	}
+	if r.caPool == nil || fi.ModTime().After(r.caMod) {
+		pem, err := os.ReadFile(r.caFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA bundle %q: %v", r.caFile, err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no CA certs parsed from %q", r.caFile)
+		}
+		r.caPool = pool
+		r.caMod = fi.ModTime()
+	}
+	return r.caPool, nil
+}
+
+func (r *reloadableCredentials) verifyPeerCertificate(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+	if r.skipVerify {
+		return nil
+	}
+
+	certs := make([]*x509.Certificate, len(rawCerts))
+	for i, raw := range rawCerts {
+		cert, err := x509.ParseCertificate(raw)
+		if err != nil {
+			return fmt.Errorf("failed to parse peer certificate: %v", err)
+		}
+		certs[i] = cert
+	}
+	if len(certs) == 0 {
+		return fmt.Errorf("server presented no certificate")
+	}
+
+	r.mu.Lock()
+	pool, err := r.caPoolLocked()
+	r.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	opts := x509.VerifyOptions{Roots: pool, DNSName: r.serverName, Intermediates: x509.NewCertPool()}
+	for _, c := range certs[1:] {
+		opts.Intermediates.AddCert(c)
+	}
+	if _, err := certs[0].Verify(opts); err != nil {
+		return err
+	}
+
+	if r.spiffeID != "" || r.spiffeTrustDomain != "" {
+		return verifySpiffeID(certs[0], r.spiffeID, r.spiffeTrustDomain)
+	}
+	return nil
+}
+
+// hostFromAddr returns the host half of a "host:port" dial address, or addr
+// unchanged if it doesn't have a port.
+func hostFromAddr(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	return host
+}
@@ -0,0 +1,57 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prober
+
+import (
+	"crypto/x509"
+	"fmt"
+)
+
+// spiffeVerifier returns a tls.Config.VerifyPeerCertificate hook that checks
+// the server's spiffe:// URI SAN, complementing (not replacing) the normal
+// hostname/CA verification Go's TLS stack already performed: verifiedChains
+// is only populated when that verification succeeded.
+func spiffeVerifier(spiffeID, spiffeTrustDomain string) func(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error {
+	return func(_ [][]byte, verifiedChains [][]*x509.Certificate) error {
+		if len(verifiedChains) == 0 || len(verifiedChains[0]) == 0 {
+			return fmt.Errorf("no verified certificate chain to check a spiffe identity against")
+		}
+		return verifySpiffeID(verifiedChains[0][0], spiffeID, spiffeTrustDomain)
+	}
+}
+
+// verifySpiffeID requires cert to carry a spiffe:// URI SAN matching
+// spiffeID exactly, or (when spiffeID is empty) belonging to
+// spiffeTrustDomain.
+func verifySpiffeID(cert *x509.Certificate, spiffeID, spiffeTrustDomain string) error {
+	for _, uri := range cert.URIs {
+		if uri.Scheme != "spiffe" {
+			continue
+		}
+		if spiffeID != "" {
+			if uri.String() == spiffeID {
+				return nil
+			}
+			continue
+		}
+		if uri.Host == spiffeTrustDomain {
+			return nil
+		}
+	}
+	if spiffeID != "" {
+		return fmt.Errorf("server certificate does not carry the expected spiffe ID %q", spiffeID)
+	}
+	return fmt.Errorf("server certificate does not carry a spiffe ID in trust domain %q", spiffeTrustDomain)
+}
@@ -0,0 +1,110 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prober
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net/url"
+	"testing"
+	"time"
+)
+
+// certWithURIs returns a self-signed certificate carrying the given URI SANs,
+// for exercising verifySpiffeID without needing real PEM fixtures.
+func certWithURIs(t *testing.T, rawURIs ...string) *x509.Certificate {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	var uris []*url.URL
+	for _, raw := range rawURIs {
+		u, err := url.Parse(raw)
+		if err != nil {
+			t.Fatalf("failed to parse URI %q: %v", raw, err)
+		}
+		uris = append(uris, u)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "test"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		URIs:         uris,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("failed to create certificate: %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("failed to parse certificate: %v", err)
+	}
+	return cert
+}
+
+func TestVerifySpiffeID_exactMatch(t *testing.T) {
+	cert := certWithURIs(t, "spiffe://example.org/workload")
+	if err := verifySpiffeID(cert, "spiffe://example.org/workload", ""); err != nil {
+		t.Fatalf("expected match, got: %v", err)
+	}
+}
+
+func TestVerifySpiffeID_idMismatch(t *testing.T) {
+	cert := certWithURIs(t, "spiffe://example.org/other")
+	if err := verifySpiffeID(cert, "spiffe://example.org/workload", ""); err == nil {
+		t.Fatal("expected error for a non-matching spiffe ID")
+	}
+}
+
+func TestVerifySpiffeID_trustDomainMatch(t *testing.T) {
+	cert := certWithURIs(t, "spiffe://example.org/workload")
+	if err := verifySpiffeID(cert, "", "example.org"); err != nil {
+		t.Fatalf("expected trust domain match, got: %v", err)
+	}
+}
+
+func TestVerifySpiffeID_trustDomainMismatch(t *testing.T) {
+	cert := certWithURIs(t, "spiffe://other.org/workload")
+	if err := verifySpiffeID(cert, "", "example.org"); err == nil {
+		t.Fatal("expected error for a non-matching trust domain")
+	}
+}
+
+func TestVerifySpiffeID_noSpiffeURI(t *testing.T) {
+	cert := certWithURIs(t)
+	if err := verifySpiffeID(cert, "spiffe://example.org/workload", ""); err == nil {
+		t.Fatal("expected error when the certificate carries no spiffe URI")
+	}
+}
+
+func TestSpiffeVerifier_noVerifiedChains(t *testing.T) {
+	verify := spiffeVerifier("spiffe://example.org/workload", "")
+	if err := verify(nil, nil); err == nil {
+		t.Fatal("expected error when no verified chain is presented")
+	}
+}
+
+func TestSpiffeVerifier_verifiedChainChecked(t *testing.T) {
+	cert := certWithURIs(t, "spiffe://example.org/workload")
+	verify := spiffeVerifier("spiffe://example.org/workload", "")
+	if err := verify(nil, [][]*x509.Certificate{{cert}}); err != nil {
+		t.Fatalf("expected match against the verified chain's leaf, got: %v", err)
+	}
+}
@@ -0,0 +1,89 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prober
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"google.golang.org/grpc"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+
+	"github.com/grpc-ecosystem/grpc-health-probe/internal/probe"
+)
+
+// Watch opens the streaming Health.Watch RPC and blocks until either the
+// server reports a status other than SERVING (returned as an error), the
+// stream fails, or duration elapses while the last reported status was
+// SERVING (returned as success). It lets the probe run as a readiness sidecar
+// that reacts to server-pushed status transitions instead of polling Check.
+func (c *checker) Watch(ctx context.Context, duration time.Duration) *Error {
+	opts := []grpc.DialOption{
+		grpc.WithUserAgent(c.config.UserAgent),
+		grpc.WithBlock()}
+	if c.config.TLS {
+		creds, err := c.buildTLSCredentials()
+		if err != nil {
+			return &Error{fmt.Sprintf("failed to initialize tls credentials. error=%v", err), StatusInvalidArguments}
+		}
+		opts = append(opts, grpc.WithTransportCredentials(creds))
+	} else {
+		opts = append(opts, grpc.WithInsecure())
+	}
+	perRPCCreds, err := c.config.buildPerRPCCredentials()
+	if err != nil {
+		return &Error{fmt.Sprintf("failed to initialize per-rpc credentials. error=%v", err), StatusInvalidArguments}
+	}
+	if perRPCCreds != nil {
+		opts = append(opts, grpc.WithPerRPCCredentials(perRPCCreds))
+	}
+
+	dialCtx, cancel := context.WithTimeout(ctx, c.config.ConnTimeout)
+	defer cancel()
+	conn, err := grpc.DialContext(dialCtx, c.config.Addr, opts...)
+	if err != nil {
+		return &Error{fmt.Sprintf("error: failed to connect service at %q: %+v", c.config.Addr, err), StatusConnectionFailure}
+	}
+	defer conn.Close()
+
+	watchCtx, watchCancel := context.WithTimeout(ctx, duration)
+	defer watchCancel()
+	stream, err := healthpb.NewHealthClient(conn).Watch(watchCtx, &healthpb.HealthCheckRequest{Service: c.config.Service})
+	if err != nil {
+		return &Error{fmt.Sprintf("error: failed to open watch stream: %+v", err), StatusRPCFailure}
+	}
+
+	err = probe.RunWatchLoop(watchCtx, stream, func(status healthpb.HealthCheckResponse_ServingStatus) {
+		c.log("watch: status=%v", status)
+	})
+	if err == nil {
+		return nil
+	}
+	var servingStatus probe.ServingStatusError
+	if errors.As(err, &servingStatus) {
+		return &Error{fmt.Sprintf("service unhealthy (responded with %q)", healthpb.HealthCheckResponse_ServingStatus(servingStatus).String()), StatusUnhealthy}
+	}
+	// Same StatusRPCFailure as the generic branch below, but classified
+	// explicitly (as checker.Check does for the unary RPC) so the friendly
+	// UnimplementedError message always wins over whatever text a future
+	// change to the generic branch's formatting might produce.
+	var unimplemented probe.UnimplementedError
+	if errors.As(err, &unimplemented) {
+		return &Error{err.Error(), StatusRPCFailure}
+	}
+	return &Error{err.Error(), StatusRPCFailure}
+}
@@ -0,0 +1,170 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/credentials"
+)
+
+// reloadableCredentials is a credentials.TransportCredentials that re-reads
+// the client cert/key pair and CA bundle from disk whenever their mtime
+// changes. The check happens lazily inside the TLS handshake callbacks, not
+// on a timer, so it only has an observable effect across the repeated
+// handshakes of -watch/-stream mode; a single one-shot probe only ever loads
+// the files once. It is the main.go analogue of the pemfile-style provider
+// used by advancedtls, scaled down to what buildCredentials already needs.
+type reloadableCredentials struct {
+	certFile, keyFile, caFile, serverName string
+	skipVerify                            bool
+	attestationVerifier                   AttestationVerifier
+
+	mu      sync.Mutex
+	certMod time.Time
+	caMod   time.Time
+	cert    *tls.Certificate
+	caPool  *x509.CertPool
+}
+
+// newReloadableCredentials returns credentials that re-read certFile, keyFile
+// and caFile from disk whenever a dial or handshake observes a newer mtime
+// than was last loaded. If serverName is empty, the host half of addr (the
+// dial target) is used instead, the same default grpc's own credentials
+// apply for the non-reloading path; verifyPeerCertificate does its own
+// manual chain verification, so without this it would never check the
+// peer's hostname at all. If attestationVerifier is non-nil, it is checked
+// against the verified chain: inline from verifyPeerCertificate when caFile
+// is reloadable (since that path builds the chain itself and Go's handshake
+// never sees one to hand to applyAttestation), or via applyAttestation
+// against Go's own verifiedChains otherwise, the same as buildCredentials.
+func newReloadableCredentials(certFile, keyFile, caFile, serverName string, skipVerify bool, addr string, attestationVerifier AttestationVerifier) credentials.TransportCredentials {
+	if serverName == "" {
+		serverName = hostFromAddr(addr)
+	}
+	r := &reloadableCredentials{
+		certFile:            certFile,
+		keyFile:             keyFile,
+		caFile:              caFile,
+		serverName:          serverName,
+		skipVerify:          skipVerify,
+		attestationVerifier: attestationVerifier,
+	}
+	cfg := &tls.Config{
+		ServerName:         serverName,
+		InsecureSkipVerify: skipVerify,
+	}
+	if certFile != "" && keyFile != "" {
+		cfg.GetClientCertificate = r.getClientCertificate
+	}
+	if caFile != "" && !skipVerify {
+		cfg.VerifyPeerCertificate = r.verifyPeerCertificate
+		cfg.InsecureSkipVerify = true // we do our own chain verification in VerifyPeerCertificate
+	} else if attestationVerifier != nil {
+		applyAttestation(cfg, attestationVerifier)
+	}
+	return credentials.NewTLS(cfg)
+}
+
+func (r *reloadableCredentials) getClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	info, err := os.Stat(r.certFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat tls client cert (%s): %v", r.certFile, err)
+	}
+	if r.cert != nil && !info.ModTime().After(r.certMod) {
+		return r.cert, nil
+	}
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reload tls client cert/key pair. error=%v", err)
+	}
+	r.cert = &cert
+	r.certMod = info.ModTime()
+	return r.cert, nil
+}
+
+func (r *reloadableCredentials) caPoolLocked() (*x509.CertPool, error) {
+	info, err := os.Stat(r.caFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat tls ca cert (%s): %v", r.caFile, err)
+	}
+	if r.caPool != nil && !info.ModTime().After(r.caMod) {
+		return r.caPool, nil
+	}
+	pem, err := ioutil.ReadFile(r.caFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load root CA certificates from file (%s) error=%v", r.caFile, err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no root CA certs parsed from file %s", r.caFile)
+	}
+	r.caPool = pool
+	r.caMod = info.ModTime()
+	return pool, nil
+}
+
+func (r *reloadableCredentials) verifyPeerCertificate(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+	r.mu.Lock()
+	pool, err := r.caPoolLocked()
+	r.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	certs := make([]*x509.Certificate, len(rawCerts))
+	for i, raw := range rawCerts {
+		cert, err := x509.ParseCertificate(raw)
+		if err != nil {
+			return fmt.Errorf("failed to parse peer certificate: %v", err)
+		}
+		certs[i] = cert
+	}
+	opts := x509.VerifyOptions{
+		Roots:         pool,
+		DNSName:       r.serverName,
+		Intermediates: x509.NewCertPool(),
+	}
+	for _, cert := range certs[1:] {
+		opts.Intermediates.AddCert(cert)
+	}
+	chains, err := certs[0].Verify(opts)
+	if err != nil {
+		return err
+	}
+	if r.attestationVerifier != nil {
+		return r.attestationVerifier.VerifyAttestation(chains[0])
+	}
+	return nil
+}
+
+// hostFromAddr returns the host half of a "host:port" dial address, or addr
+// unchanged if it doesn't have a port.
+func hostFromAddr(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	return host
+}
@@ -0,0 +1,84 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/health"
+	healthgrpc "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// testdataPath returns the path to a file under internal/probe/testdata,
+// reused here rather than duplicating a second set of test certificates.
+func testdataPath(name string) string {
+	return filepath.Join("internal", "probe", "testdata", name)
+}
+
+// startTLSServer runs a health server presenting certFile/keyFile and
+// returns the addr to dial.
+func startTLSServer(t *testing.T, certFile, keyFile string) string {
+	t.Helper()
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		t.Fatalf("failed to load server cert: %v", err)
+	}
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	srv := grpc.NewServer(grpc.Creds(credentials.NewTLS(&tls.Config{Certificates: []tls.Certificate{cert}})))
+	healthgrpc.RegisterHealthServer(srv, health.NewServer())
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+	return lis.Addr().String()
+}
+
+func dialWithCreds(addr string, creds credentials.TransportCredentials) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	conn, err := grpc.DialContext(ctx, addr, grpc.WithTransportCredentials(creds), grpc.WithBlock())
+	if err == nil {
+		conn.Close()
+	}
+	return err
+}
+
+func TestReloadableCredentials_defaultsServerNameFromAddr(t *testing.T) {
+	addr := startTLSServer(t, testdataPath("127.0.0.1.pem"), testdataPath("127.0.0.1-key.pem"))
+
+	creds := newReloadableCredentials("", "", testdataPath("ca.pem"), "", false, addr, nil)
+	if err := dialWithCreds(addr, creds); err != nil {
+		t.Fatalf("dial with no -tls-server-name failed, want the dial address's host to be used instead: %v", err)
+	}
+}
+
+func TestReloadableCredentials_rejectsHostnameMismatch(t *testing.T) {
+	// The server's cert is for example.com, but it's dialed over 127.0.0.1:
+	// without a -tls-server-name default, verifyPeerCertificate must still
+	// catch the mismatch instead of silently accepting any hostname.
+	addr := startTLSServer(t, testdataPath("example.com.pem"), testdataPath("example.com-key.pem"))
+
+	creds := newReloadableCredentials("", "", testdataPath("ca.pem"), "", false, addr, nil)
+	if err := dialWithCreds(addr, creds); err == nil {
+		t.Fatal("dial succeeded despite a certificate/hostname mismatch")
+	}
+}
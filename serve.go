@@ -0,0 +1,176 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"google.golang.org/grpc"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+)
+
+var (
+	flServeMode    bool
+	flListen       string
+	flServeTLSCert string
+	flServeTLSKey  string
+)
+
+// parseServeArgs parses the `serve` subcommand's flags from args
+// (os.Args[2:]). It registers the outbound gRPC dial flags shared with the
+// top-level probe (-tls*, -alts, -spiffe*, -rpc-header, -connect-timeout,
+// -rpc-timeout) alongside the HTTP listener flags. Unlike the top-level
+// probe it does not require -addr/-service: those are supplied per request
+// as the "target" and "service" query parameters of GET /healthz.
+func parseServeArgs(args []string) {
+	flagSet := flag.NewFlagSet("serve", flag.ContinueOnError)
+	flagSet.StringVar(&flListen, "listen", ":8080", "HTTP address for the health gateway to listen on")
+	flagSet.StringVar(&flServeTLSCert, "serve-tls-cert", "", "(optional) serve HTTPS using this certificate file (requires -serve-tls-key)")
+	flagSet.StringVar(&flServeTLSKey, "serve-tls-key", "", "(with -serve-tls-cert) private key file for the HTTPS listener")
+	flagSet.StringVar(&flUserAgent, "user-agent", "grpc_health_probe", "user-agent header value of health check requests")
+	flagSet.DurationVar(&flConnTimeout, "connect-timeout", time.Second, "timeout for establishing the outbound gRPC connection")
+	flagSet.Var(&flRPCHeaders, "rpc-header", "additional outbound RPC headers in 'name: value' format. May specify more than one via multiple flags.")
+	flagSet.DurationVar(&flRPCTimeout, "rpc-timeout", time.Second, "timeout for the outbound health check rpc")
+	flagSet.BoolVar(&flTLS, "tls", false, "use TLS for the outbound gRPC health check (default: false, INSECURE plaintext transport)")
+	flagSet.BoolVar(&flTLSNoVerify, "tls-no-verify", false, "(with -tls) don't verify the certificate (INSECURE) presented by the server")
+	flagSet.StringVar(&flTLSCACert, "tls-ca-cert", "", "(with -tls, optional) file containing trusted certificates for verifying the server")
+	flagSet.StringVar(&flTLSClientCert, "tls-client-cert", "", "(with -tls, optional) client certificate for authenticating to the server (requires -tls-client-key)")
+	flagSet.StringVar(&flTLSClientKey, "tls-client-key", "", "(with -tls) client private key for authenticating to the server (requires -tls-client-cert)")
+	flagSet.StringVar(&flTLSServerName, "tls-server-name", "", "(with -tls) override the hostname used to verify the server certificate")
+	flagSet.BoolVar(&flALTS, "alts", false, "use ALTS for the outbound gRPC health check (default: false, INSECURE plaintext transport)")
+	flagSet.BoolVar(&flSPIFFE, "spiffe", false, "use SPIFFE to obtain mTLS credentials for the outbound gRPC health check")
+	flagSet.Var(&flSpiffeAuthorize, "spiffe-authorize", "(with -spiffe, optional) allowed server SPIFFE ID or \"spiffe://<trust-domain>/*\" wildcard; may be specified more than once (default: accept any SPIFFE ID)")
+	flagSet.BoolVar(&flVerbose, "v", false, "verbose logs")
+
+	if err := flagSet.Parse(args); err != nil {
+		os.Exit(StatusInvalidArguments)
+	}
+
+	argError := func(s string, v ...interface{}) {
+		log.Printf("error: "+s, v...)
+		os.Exit(StatusInvalidArguments)
+	}
+	if flServeTLSCert != "" && flServeTLSKey == "" {
+		argError("specified -serve-tls-cert without specifying -serve-tls-key")
+	}
+	if flServeTLSCert == "" && flServeTLSKey != "" {
+		argError("specified -serve-tls-key without specifying -serve-tls-cert")
+	}
+	if flTLS && flALTS {
+		argError("cannot specify -tls with -alts")
+	}
+	if flALTS && flSPIFFE {
+		argError("-alts and -spiffe are mutually incompatible")
+	}
+}
+
+// healthzResponse is the JSON body returned by GET /healthz.
+type healthzResponse struct {
+	Target  string `json:"target"`
+	Service string `json:"service"`
+	Status  string `json:"status"`
+	RPCMS   int64  `json:"rpc_ms"`
+	Err     string `json:"error,omitempty"`
+}
+
+// serveHealthz handles GET /healthz?target=host:port&service=name by
+// performing a single gRPC health probe against target and reporting the
+// outcome as a 200/503 plus a JSON body.
+func serveHealthz(w http.ResponseWriter, r *http.Request) {
+	target := r.URL.Query().Get("target")
+	if target == "" {
+		http.Error(w, "missing required query parameter: target", http.StatusBadRequest)
+		return
+	}
+	service := r.URL.Query().Get("service")
+
+	result := probeServeTarget(r.Context(), target, service)
+
+	w.Header().Set("Content-Type", "application/json")
+	if result.Err == "" {
+		w.WriteHeader(http.StatusOK)
+	} else {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	if err := json.NewEncoder(w).Encode(result); err != nil {
+		log.Printf("error: failed to encode /healthz response: %v", err)
+	}
+}
+
+// probeServeTarget dials target and issues a single Health/Check for
+// service, reusing the outbound gRPC flags parsed by parseServeArgs. Unlike
+// runProbe it is parameterized by target/service rather than reading the
+// -addr/-service globals, since one serve process handles concurrent
+// requests against many different targets.
+func probeServeTarget(ctx context.Context, target, service string) healthzResponse {
+	opts, retcode := buildDialOptions(ctx)
+	if retcode != 0 {
+		return healthzResponse{Target: target, Service: service, Err: "failed to build gRPC dial options, see server logs"}
+	}
+
+	dialCtx, dialCancel := context.WithTimeout(ctx, flConnTimeout)
+	defer dialCancel()
+	conn, err := grpc.DialContext(dialCtx, target, opts...)
+	if err != nil {
+		return healthzResponse{Target: target, Service: service, Err: fmt.Sprintf("failed to connect service at %q: %+v", target, err)}
+	}
+	defer conn.Close()
+
+	rpcCtx, rpcCancel := context.WithTimeout(ctx, flRPCTimeout)
+	defer rpcCancel()
+	rpcCtx = metadata.NewOutgoingContext(rpcCtx, flRPCHeaders.MD)
+	outcome := doHealthCheck(rpcCtx, healthpb.NewHealthClient(conn), service, flRPCTimeout)
+
+	result := healthzResponse{Target: target, Service: service, Err: outcome.Err, RPCMS: outcome.Duration.Milliseconds()}
+	if outcome.Err == "" {
+		result.Status = outcome.Status.String()
+	}
+	return result
+}
+
+// runServe starts the HTTP health gateway and blocks until ctx is canceled
+// or the listener fails.
+func runServe(ctx context.Context) int {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", serveHealthz)
+	server := &http.Server{Addr: flListen, Handler: mux}
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer shutdownCancel()
+		server.Shutdown(shutdownCtx)
+	}()
+
+	log.Printf("serving GET /healthz on %s", flListen)
+	var err error
+	if flServeTLSCert != "" {
+		err = server.ListenAndServeTLS(flServeTLSCert, flServeTLSKey)
+	} else {
+		err = server.ListenAndServe()
+	}
+	if err != nil && err != http.ErrServerClosed {
+		log.Printf("error: http server failed: %v", err)
+		return StatusConnectionFailure
+	}
+	return 0
+}
@@ -0,0 +1,105 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"crypto/x509"
+	"fmt"
+	"strings"
+
+	"github.com/spiffe/go-spiffe/v2/spiffeid"
+	"github.com/spiffe/go-spiffe/v2/spiffetls/tlsconfig"
+)
+
+// spiffeMatchers collects the values of repeated -spiffe-authorize flags.
+type spiffeMatchers []string
+
+func (m *spiffeMatchers) String() string { return strings.Join(*m, ",") }
+
+func (m *spiffeMatchers) Set(value string) error {
+	*m = append(*m, value)
+	return nil
+}
+
+// buildSpiffeAuthorizer turns the -spiffe-authorize matchers into a
+// tlsconfig.Authorizer. A matcher of the form "spiffe://<trust-domain>/*"
+// authorizes any SVID in that trust domain via AuthorizeMemberOf; any other
+// matcher is an exact SPIFFE ID authorized via AuthorizeID/AuthorizeOneOf.
+// The peer is authorized if it matches any matcher. An empty matcher list
+// authorizes any peer, matching the previous -spiffe default.
+func buildSpiffeAuthorizer(matchers []string) (tlsconfig.Authorizer, error) {
+	if len(matchers) == 0 {
+		return tlsconfig.AuthorizeAny(), nil
+	}
+
+	var ids []spiffeid.ID
+	var trustDomains []spiffeid.TrustDomain
+	for _, m := range matchers {
+		if strings.HasSuffix(m, "/*") {
+			td, err := spiffeid.TrustDomainFromString(strings.TrimSuffix(m, "/*"))
+			if err != nil {
+				return nil, fmt.Errorf("invalid -spiffe-authorize trust domain matcher %q: %v", m, err)
+			}
+			trustDomains = append(trustDomains, td)
+			continue
+		}
+		id, err := spiffeid.FromString(m)
+		if err != nil {
+			return nil, fmt.Errorf("invalid -spiffe-authorize matcher %q: %v", m, err)
+		}
+		ids = append(ids, id)
+	}
+
+	if len(trustDomains) == 0 {
+		inner := tlsconfig.AuthorizeOneOf(ids...)
+		if len(ids) == 1 {
+			inner = tlsconfig.AuthorizeID(ids[0])
+		}
+		return func(id spiffeid.ID, verifiedChains [][]*x509.Certificate) error {
+			if err := inner(id, verifiedChains); err != nil {
+				return &spiffeAuthorizationError{id: id.String()}
+			}
+			return nil
+		}, nil
+	}
+
+	idAuthorizer := tlsconfig.AuthorizeOneOf(ids...)
+	return func(id spiffeid.ID, verifiedChains [][]*x509.Certificate) error {
+		if len(ids) > 0 && idAuthorizer(id, verifiedChains) == nil {
+			return nil
+		}
+		for _, td := range trustDomains {
+			if tlsconfig.AuthorizeMemberOf(td)(id, verifiedChains) == nil {
+				return nil
+			}
+		}
+		return &spiffeAuthorizationError{id: id.String()}
+	}, nil
+}
+
+// spiffeAuthorizationError marks a rejection by buildSpiffeAuthorizer so
+// main can tell an unauthorized peer identity apart from an ordinary
+// connection failure and exit with StatusSpiffeFailed instead.
+type spiffeAuthorizationError struct{ id string }
+
+func (e *spiffeAuthorizationError) Error() string {
+	return fmt.Sprintf("unauthorized SPIFFE ID %q does not match any -spiffe-authorize matcher", e.id)
+}
+
+// Temporary reports this error as non-temporary so grpc's
+// FailOnNonTempDialError (set alongside the -spiffe dial credentials) fails
+// the dial immediately on rejection instead of retrying the handshake until
+// the dial context deadline, which would otherwise mask this error behind a
+// generic context.DeadlineExceeded.
+func (e *spiffeAuthorizationError) Temporary() bool { return false }
@@ -0,0 +1,28 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import "testing"
+
+func TestSpiffeAuthorizationError_notTemporary(t *testing.T) {
+	// buildDialOptions pairs the -spiffe dial credentials with
+	// grpc.FailOnNonTempDialError(true) specifically so this error aborts the
+	// dial immediately instead of being retried until the dial times out; if
+	// it ever reports itself as temporary, that fast-fail path stops working
+	// and runProbe's errors.As(err, &authzErr) below becomes unreachable.
+	err := &spiffeAuthorizationError{id: "spiffe://example.org/workload"}
+	if err.Temporary() {
+		t.Fatal("spiffeAuthorizationError.Temporary() = true, want false")
+	}
+}
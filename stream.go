@@ -0,0 +1,111 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// runStream dials flAddr with opts and consumes the server-streamed
+// Health.Watch RPC instead of issuing one-shot Checks, logging (or emitting
+// as -json) every status transition the server pushes. It blocks until the
+// stream ends, -stream-max-duration elapses, ctx is cancelled, or (with
+// -exit-on-not-serving) the server first reports anything but SERVING.
+//
+// Unlike internal/probe's Watch and pkg/prober's (*checker).Watch (which
+// share probe.RunWatchLoop), this loop deliberately does not fail fast on
+// NOT_SERVING/SERVICE_UNKNOWN and doesn't require the last status to be
+// SERVING for a clean exit: -stream is a continuous tail of every
+// transition, gated only by -exit-on-not-serving, not a one-shot readiness
+// gate, so it isn't a candidate for that shared helper.
+func runStream(ctx context.Context) int {
+	opts, retcode := buildDialOptions(ctx)
+	if retcode != 0 {
+		return retcode
+	}
+
+	if flVerbose {
+		log.Print("establishing connection")
+	}
+	dialCtx, dialCancel := context.WithTimeout(ctx, flConnTimeout)
+	defer dialCancel()
+	conn, err := grpc.DialContext(dialCtx, flAddr, opts...)
+	if err != nil {
+		log.Printf("error: failed to connect service at %q: %+v", flAddr, err)
+		return StatusConnectionFailure
+	}
+	defer conn.Close()
+
+	streamCtx := ctx
+	if flStreamMaxDuration > 0 {
+		var streamCancel context.CancelFunc
+		streamCtx, streamCancel = context.WithTimeout(ctx, flStreamMaxDuration)
+		defer streamCancel()
+	}
+	streamCtx = metadata.NewOutgoingContext(streamCtx, flRPCHeaders.MD)
+
+	stream, err := healthpb.NewHealthClient(conn).Watch(streamCtx, &healthpb.HealthCheckRequest{Service: flService})
+	if err != nil {
+		log.Printf("error: failed to open watch stream: %+v", err)
+		return StatusRPCFailure
+	}
+
+	for {
+		resp, err := stream.Recv()
+		if err != nil {
+			if streamCtx.Err() != nil {
+				return 0
+			}
+			log.Printf("error: watch stream failed: %+v", err)
+			return StatusRPCFailure
+		}
+		logStreamEvent(resp.GetStatus())
+		if flExitOnNotServing && resp.GetStatus() != healthpb.HealthCheckResponse_SERVING {
+			return StatusUnhealthy
+		}
+	}
+}
+
+// logStreamEvent reports a single Watch status transition as either a JSON
+// line (-json) or a human-readable log line.
+func logStreamEvent(status healthpb.HealthCheckResponse_ServingStatus) {
+	if flJSON {
+		type jsonEvent struct {
+			TS     string `json:"ts"`
+			Addr   string `json:"addr"`
+			Status string `json:"status"`
+		}
+		out, err := json.Marshal(jsonEvent{
+			TS:     time.Now().UTC().Format(time.RFC3339Nano),
+			Addr:   flAddr,
+			Status: status.String(),
+		})
+		if err != nil {
+			log.Printf("error: failed to marshal -json watch event: %v", err)
+			return
+		}
+		fmt.Println(string(out))
+		return
+	}
+	log.Printf("status: %v", status.String())
+}